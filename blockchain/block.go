@@ -3,9 +3,13 @@ package blockchain
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"time"
 
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
@@ -19,14 +23,52 @@ type Block struct {
 	height        int
 }
 
-// NewGenesisBlock creates and returns genesis Block
-func NewGenesisBlock(coinbase *transaction.Transaction) *Block {
-	return NewBlock([]*transaction.Transaction{coinbase}, []byte{}, 0)
+// blockData is the serialized form of a Block
+type blockData struct {
+	Timestamp     int64
+	Transactions  []*transaction.Transaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+}
+
+// NewGenesisBlock creates and returns the genesis Block of the active network
+// Every field comes from the network parameters, so every node builds the same block
+func NewGenesisBlock() *Block {
+	return NewBlockWithTimestamp([]*transaction.Transaction{genesisCoinbase()}, []byte{}, 0, chaincfg.ActiveParams().GenesisTimestamp())
+}
+
+// IsGenesis tells whether the block is the genesis block of the active network
+// Without a genesis hash in the parameters the content of the block is checked, which spares mining the genesis block again
+func (b *Block) IsGenesis() bool {
+	params := chaincfg.ActiveParams()
+	if params.GenesisHash() != nil {
+		return bytes.Equal(b.hash, params.GenesisHash())
+	}
+
+	return len(b.prevBlockHash) == 0 &&
+		b.timestamp == params.GenesisTimestamp() &&
+		len(b.transactions) == 1 &&
+		bytes.Equal(b.transactions[0].ID(), genesisCoinbase().ID()) &&
+		NewProofOfWork(b).Validate()
+}
+
+// genesisCoinbase creates the coinbase transaction of the genesis block of the active network
+func genesisCoinbase() *transaction.Transaction {
+	params := chaincfg.ActiveParams()
+
+	return transaction.NewCoinbaseTX(params.GenesisAddress(), params.GenesisCoinbaseData(), 0)
 }
 
 // NewBlock creates and returns Block
 func NewBlock(transactions []*transaction.Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
+	return NewBlockWithTimestamp(transactions, prevBlockHash, height, time.Now().Unix())
+}
+
+// NewBlockWithTimestamp creates and returns a Block carrying the given Unix timestamp instead of the current time
+func NewBlockWithTimestamp(transactions []*transaction.Transaction, prevBlockHash []byte, height int, timestamp int64) *Block {
+	block := &Block{timestamp, transactions, prevBlockHash, []byte{}, 0, height}
 	pow := NewProofOfWork(block)
 	nonce, hash := pow.Run()
 
@@ -37,15 +79,43 @@ func NewBlock(transactions []*transaction.Transaction, prevBlockHash []byte, hei
 }
 
 func DeserializeBlock(d []byte) *Block {
-	var block Block
+	block, err := DecodeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	decoder := gob.NewDecoder(bytes.NewReader(d))
+	return block
+}
 
-	if err := decoder.Decode(&block); err != nil {
-		log.Panic(err)
+// DecodeBlock deserializes a block that may be malformed, such as one received from a peer
+func DecodeBlock(d []byte) (*Block, error) {
+	var block Block
+
+	if err := gob.NewDecoder(bytes.NewReader(d)).Decode(&block); err != nil {
+		return nil, err
 	}
 
-	return &block
+	return &block, nil
+}
+
+// GobEncode encodes the Block, gob can't reach its unexported fields
+func (b *Block) GobEncode() ([]byte, error) {
+	var result bytes.Buffer
+
+	err := gob.NewEncoder(&result).Encode(blockData{b.timestamp, b.transactions, b.prevBlockHash, b.hash, b.nonce, b.height})
+
+	return result.Bytes(), err
+}
+
+// GobDecode decodes a Block encoded by GobEncode
+func (b *Block) GobDecode(data []byte) error {
+	var decoded blockData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded)
+	b.timestamp, b.transactions, b.prevBlockHash = decoded.Timestamp, decoded.Transactions, decoded.PrevBlockHash
+	b.hash, b.nonce, b.height = decoded.Hash, decoded.Nonce, decoded.Height
+
+	return err
 }
 
 func (b *Block) Timestamp() int64 {
@@ -84,13 +154,69 @@ func (b *Block) Serialize() []byte {
 }
 
 func (b *Block) HashTransactions() []byte {
-	var transactions [][]byte
+	mTree := utils.NewMerkleTree(b.encodedTransactions())
+
+	return mTree.RootNode().Data()
+}
+
+// CheckTransactions checks what the block says about its own transactions: a single coinbase paying no more than
+// the subsidy, IDs that are the hashes of the transactions and amounts in range
+func (b *Block) CheckTransactions() error {
+	coinbases := 0
 
 	for _, tx := range b.transactions {
-		transactions = append(transactions, tx.Serialize())
+		if !bytes.Equal(tx.ID(), tx.UnsignedHash()) {
+			return fmt.Errorf("transaction %x is not the hash of its content", tx.ID())
+		}
+
+		value := 0
+		for _, out := range tx.Vout() {
+			if out.Value() < 0 || out.Value() > math.MaxInt-value {
+				return fmt.Errorf("transaction %x has an output out of range", tx.ID())
+			}
+
+			value += out.Value()
+		}
+
+		if tx.IsCoinbase() {
+			coinbases++
+
+			if value > chaincfg.ActiveParams().Subsidy(b.height) {
+				return fmt.Errorf("coinbase %x pays %d, more than the subsidy", tx.ID(), value)
+			}
+		}
 	}
 
-	mTree := utils.NewMerkleTree(transactions)
+	if coinbases != 1 {
+		return errors.New("the block does not have a single coinbase")
+	}
 
-	return mTree.RootNode().Data()
+	return nil
+}
+
+// FilterTransactions returns the transactions of the block the bloom filter wants,
+// with the partial Merkle tree proving they are in the block
+func (b *Block) FilterTransactions(filter *utils.BloomFilter) (*utils.PartialMerkleTree, []*transaction.Transaction) {
+	var matched []*transaction.Transaction
+	matches := make([]bool, len(b.transactions))
+
+	for i, tx := range b.transactions {
+		if tx.MatchesFilter(filter) {
+			matches[i] = true
+			matched = append(matched, tx)
+		}
+	}
+
+	return utils.NewPartialMerkleTree(b.encodedTransactions(), matches), matched
+}
+
+// encodedTransactions returns the encoded transactions of the block, the leaves of its Merkle tree
+func (b *Block) encodedTransactions() [][]byte {
+	var transactions [][]byte
+
+	for _, tx := range b.transactions {
+		transactions = append(transactions, tx.Bytes())
+	}
+
+	return transactions
 }
@@ -8,24 +8,32 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"time"
 
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
 	"go.etcd.io/bbolt"
 )
 
 const (
-	blocksBucket        = "blocks"
-	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+	blocksBucket = "blocks"
+	networkKey   = "network"
+	genesisKey   = "genesis"
+	// heightsBucket maps the heights of the main chain to the hashes of its blocks
+	heightsBucket = "heights"
 )
 
+var errUnknownParent = errors.New("ERROR: The parent of the block is unknown")
+
 type Blockchain struct {
 	tip []byte
 	db  *bbolt.DB
 }
 
-// CreateBlockchain creates a new blockchain DB
-func CreateBlockchain(address string, nodeId string) *Blockchain {
+// CreateBlockchain creates a new blockchain DB starting from the genesis block of the active network
+func CreateBlockchain(nodeId string) *Blockchain {
 	if utils.CheckDB(nodeId) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
@@ -33,8 +41,10 @@ func CreateBlockchain(address string, nodeId string) *Blockchain {
 
 	var tip []byte
 
-	cbtx := transaction.NewCoinbaseTX(address, genesisCoinbaseData)
-	genesis := NewGenesisBlock(cbtx)
+	genesis := NewGenesisBlock()
+	if !genesis.IsGenesis() {
+		log.Panicf("ERROR: Genesis block %x does not have the hash set in the chain parameters", genesis.Hash())
+	}
 
 	db, err := bbolt.Open(utils.GetDBPath(nodeId), 0600, nil)
 	if err != nil {
@@ -57,6 +67,26 @@ func CreateBlockchain(address string, nodeId string) *Blockchain {
 			log.Panic(err)
 		}
 
+		err = b.Put([]byte(networkKey), []byte(chaincfg.ActiveParams().Name()))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = b.Put([]byte(genesisKey), genesis.Hash())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		heights, err := tx.CreateBucket([]byte(heightsBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = heights.Put(utils.IntToHex(0), genesis.Hash())
+		if err != nil {
+			log.Panic(err)
+		}
+
 		tip = genesis.Hash()
 		return nil
 	})
@@ -76,6 +106,8 @@ func NewBlockchain(nodeId string) *Blockchain {
 	}
 
 	var tip []byte
+	var network string
+	var genesisHash []byte
 	db, err := bbolt.Open(utils.GetDBPath(nodeId), 0600, nil)
 	if err != nil {
 		log.Panic(err)
@@ -83,7 +115,10 @@ func NewBlockchain(nodeId string) *Blockchain {
 
 	err = db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		// bbolt only keeps the values valid during the transaction
+		tip = bytes.Clone(b.Get([]byte("l")))
+		network = string(b.Get([]byte(networkKey)))
+		genesisHash = bytes.Clone(b.Get([]byte(genesisKey)))
 
 		return nil
 	})
@@ -92,7 +127,50 @@ func NewBlockchain(nodeId string) *Blockchain {
 		log.Panic(err)
 	}
 
-	return &Blockchain{tip, db}
+	// Chains created before networks existed are mainnet chains
+	if network == "" {
+		network = chaincfg.MainNet
+	}
+
+	if network != chaincfg.ActiveParams().Name() {
+		fmt.Printf("The blockchain belongs to %s, not %s.\n", network, chaincfg.ActiveParams().Name())
+		os.Exit(1)
+	}
+
+	bc := &Blockchain{tip, db}
+
+	// Chains created before the genesis hash was stored are walked back to it
+	if genesisHash == nil {
+		hashes := bc.GetBlockHashes()
+		genesisHash = hashes[len(hashes)-1]
+	}
+
+	genesis, err := bc.GetBlock(genesisHash)
+	if err != nil || !genesis.IsGenesis() {
+		fmt.Printf("The blockchain starts from genesis block %x, not the one of %s.\n", genesisHash, chaincfg.ActiveParams().Name())
+		os.Exit(1)
+	}
+
+	// Chains created before the height index existed get it built, and it catches up with blocks older versions added
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(heightsBucket))
+		if err != nil {
+			return err
+		}
+
+		return setTip(tx, DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(tip)))
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return bc
+}
+
+// Tip returns the hash of the latest block
+func (bc *Blockchain) Tip() []byte {
+	return bc.tip
 }
 
 // GetDB returns instance of bbolt.DB
@@ -100,9 +178,10 @@ func (bc *Blockchain) GetDB() *bbolt.DB {
 	return bc.db
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.db.Update(func(tx *bbolt.Tx) error {
+// AddBlock saves the block into the blockchain and makes it the tip when it is higher than the current one
+// A block whose parent is unknown is not saved, every saved block links back to the genesis block
+func (bc *Blockchain) AddBlock(block *Block) error {
+	return bc.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockInDb := b.Get(block.Hash())
 
@@ -110,6 +189,10 @@ func (bc *Blockchain) AddBlock(block *Block) {
 			return nil
 		}
 
+		if b.Get(block.PrevBlockHash()) == nil {
+			return errUnknownParent
+		}
+
 		blockData := block.Serialize()
 		err := b.Put(block.Hash(), blockData)
 		if err != nil {
@@ -121,7 +204,7 @@ func (bc *Blockchain) AddBlock(block *Block) {
 		lastBlock := DeserializeBlock(lastBlockData)
 
 		if block.Height() > lastBlock.Height() {
-			err = b.Put([]byte("l"), block.Hash())
+			err = setTip(tx, block)
 			if err != nil {
 				log.Panic(err)
 			}
@@ -130,9 +213,59 @@ func (bc *Blockchain) AddBlock(block *Block) {
 
 		return nil
 	})
+}
+
+// Branch returns the blocks of the branch of the block that are not in the main chain, lowest first, the block included
+// The block itself doesn't have to be saved yet
+func (bc *Blockchain) Branch(block *Block) ([]*Block, error) {
+	branch := []*Block{block}
+
+	for hash := block.PrevBlockHash(); ; {
+		if _, ok := bc.MainChainHeight(hash); ok {
+			break
+		}
 
+		parent, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, errUnknownParent
+		}
+
+		branch = append(branch, &parent)
+		hash = parent.PrevBlockHash()
+	}
+
+	slices.Reverse(branch)
+
+	return branch, nil
+}
+
+// setTip makes the block the tip of the chain
+// The height index is rewritten back to where the branch of the block joins the main chain
+func setTip(tx *bbolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	heights := tx.Bucket([]byte(heightsBucket))
+
+	err := b.Put([]byte("l"), block.Hash())
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	for current := block; ; {
+		key := utils.IntToHex(int64(current.Height()))
+		if bytes.Equal(heights.Get(key), current.Hash()) {
+			return nil
+		}
+
+		err = heights.Put(key, current.Hash())
+		if err != nil {
+			return err
+		}
+
+		if len(current.PrevBlockHash()) == 0 {
+			return nil
+		}
+
+		current = DeserializeBlock(b.Get(current.PrevBlockHash()))
 	}
 }
 
@@ -159,10 +292,15 @@ func (bc *Blockchain) FindTransaction(id []byte) (transaction.Transaction, error
 
 // FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
 func (bc *Blockchain) FindUTXO() map[string]transaction.TXOutputs {
+	return bc.FindUTXOAt(bc.tip)
+}
+
+// FindUTXOAt finds the unspent transaction outputs of the chain ending with the block with the hash
+func (bc *Blockchain) FindUTXOAt(hash []byte) map[string]transaction.TXOutputs {
 	utxo := make(map[string]transaction.TXOutputs)
 	spentTXOs := make(map[string][]int)
 
-	iterator := bc.Iterator()
+	iterator := &BlockchainIterator{hash, bc.db}
 
 	for {
 		block := iterator.Next()
@@ -238,6 +376,8 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 			return errors.New("Block is not found.")
 		}
 
+		block = *DeserializeBlock(blockData)
+
 		return nil
 	})
 
@@ -267,6 +407,11 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 
 // MineBlock mines a new block with the provided transactions
 func (bc *Blockchain) MineBlock(transactions []*transaction.Transaction) *Block {
+	return bc.MineBlockWithTimestamp(transactions, time.Now().Unix())
+}
+
+// MineBlockWithTimestamp mines a new block with the provided transactions and the given Unix timestamp
+func (bc *Blockchain) MineBlockWithTimestamp(transactions []*transaction.Transaction, timestamp int64) *Block {
 	var lastHash []byte
 	var lastHeight int
 
@@ -291,7 +436,7 @@ func (bc *Blockchain) MineBlock(transactions []*transaction.Transaction) *Block
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	newBlock := NewBlockWithTimestamp(transactions, lastHash, lastHeight+1, timestamp)
 
 	err = bc.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
@@ -300,7 +445,7 @@ func (bc *Blockchain) MineBlock(transactions []*transaction.Transaction) *Block
 			log.Panic(err)
 		}
 
-		err = b.Put([]byte("l"), newBlock.Hash())
+		err = setTip(tx, newBlock)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -319,38 +464,53 @@ func (bc *Blockchain) MineBlock(transactions []*transaction.Transaction) *Block
 
 // SignTransaction signs inputs of a Transaction
 func (bc *Blockchain) SignTransaction(tx *transaction.Transaction, privateKey ecdsa.PrivateKey) {
-	prevTxs := make(map[string]transaction.Transaction)
+	prevTxs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	for _, vin := range tx.Vin() {
-		prevTx, err := bc.FindTransaction(vin.TxId())
-		if err != nil {
-			log.Panic(err)
-		}
+	tx.Sign(privateKey, prevTxs)
+}
 
-		prevTxs[hex.EncodeToString(prevTx.ID())] = prevTx
+// SignTransactionWithKeys signs each input of a Transaction with the key owning the spent output
+func (bc *Blockchain) SignTransactionWithKeys(tx *transaction.Transaction, privateKeys map[string]ecdsa.PrivateKey) {
+	prevTxs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	tx.Sign(privateKey, prevTxs)
+	tx.SignWithKeys(privateKeys, prevTxs)
 }
 
 // VerifyTransaction verifies transaction input signatures
+// A transaction spending outputs of transactions that are not in the chain is invalid
 func (bc *Blockchain) VerifyTransaction(tx *transaction.Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
+	prevTxs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		return false
+	}
+
+	return tx.Verify(prevTxs)
+}
+
+// FindPrevTransactions returns the transactions referenced by the inputs, indexed by hex-encoded ID
+func (bc *Blockchain) FindPrevTransactions(tx *transaction.Transaction) (map[string]transaction.Transaction, error) {
 	prevTxs := make(map[string]transaction.Transaction)
 
 	for _, vin := range tx.Vin() {
 		prevTx, err := bc.FindTransaction(vin.TxId())
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		prevTxs[hex.EncodeToString(prevTx.ID())] = prevTx
 	}
 
-	return tx.Verify(prevTxs)
+	return prevTxs, nil
 }
 
 // Close closes db connection
@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+)
+
+// BlockHeader is a Block without its transactions, it commits to them through their merkle root
+// Its proof of work can be checked without the transactions, which lets a node follow the chain before downloading it
+type BlockHeader struct {
+	prevBlockHash []byte
+	merkleRoot    []byte
+	timestamp     int64
+	nonce         int
+	height        int
+	hash          []byte
+}
+
+// headerData is the serialized form of a BlockHeader
+type headerData struct {
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Nonce         int
+	Height        int
+	Hash          []byte
+}
+
+// Header returns the header of the Block
+func (b *Block) Header() *BlockHeader {
+	return &BlockHeader{b.prevBlockHash, b.HashTransactions(), b.timestamp, b.nonce, b.height, b.hash}
+}
+
+// NewBlockFromHeader puts a block back together from its header and transactions
+// The block is only the one of the header when it passes its proof of work check, which covers the merkle root of the transactions
+func NewBlockFromHeader(header *BlockHeader, transactions []*transaction.Transaction) *Block {
+	return &Block{header.timestamp, transactions, header.prevBlockHash, header.hash, header.nonce, header.height}
+}
+
+func DeserializeHeader(d []byte) *BlockHeader {
+	var header BlockHeader
+
+	if err := gob.NewDecoder(bytes.NewReader(d)).Decode(&header); err != nil {
+		log.Panic(err)
+	}
+
+	return &header
+}
+
+// GobEncode encodes the BlockHeader, gob can't reach its unexported fields
+func (h *BlockHeader) GobEncode() ([]byte, error) {
+	var result bytes.Buffer
+
+	err := gob.NewEncoder(&result).Encode(headerData{h.prevBlockHash, h.merkleRoot, h.timestamp, h.nonce, h.height, h.hash})
+
+	return result.Bytes(), err
+}
+
+// GobDecode decodes a BlockHeader encoded by GobEncode
+func (h *BlockHeader) GobDecode(data []byte) error {
+	var decoded headerData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded)
+	h.prevBlockHash, h.merkleRoot, h.timestamp = decoded.PrevBlockHash, decoded.MerkleRoot, decoded.Timestamp
+	h.nonce, h.height, h.hash = decoded.Nonce, decoded.Height, decoded.Hash
+
+	return err
+}
+
+func (h *BlockHeader) PrevBlockHash() []byte {
+	return h.prevBlockHash
+}
+
+func (h *BlockHeader) MerkleRoot() []byte {
+	return h.merkleRoot
+}
+
+func (h *BlockHeader) Timestamp() int64 {
+	return h.timestamp
+}
+
+func (h *BlockHeader) Nonce() int {
+	return h.nonce
+}
+
+func (h *BlockHeader) Height() int {
+	return h.height
+}
+
+func (h *BlockHeader) Hash() []byte {
+	return h.hash
+}
+
+func (h *BlockHeader) Serialize() []byte {
+	var result bytes.Buffer
+
+	if err := gob.NewEncoder(&result).Encode(h); err != nil {
+		log.Panic(err)
+	}
+
+	return result.Bytes()
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"go.etcd.io/bbolt"
+)
+
+// locatorDenseHashes is how many of the latest blocks a locator lists one by one before it starts skipping
+const locatorDenseHashes = 10
+
+// LocatorHeights returns the heights a block locator lists for a chain whose tip is at the height
+// The latest blocks come one by one, then the steps double back to the genesis block, so the locator stays short
+// and still finds where two chains fork
+func LocatorHeights(height int) []int {
+	var heights []int
+	step := 1
+
+	for ; height > 0; height -= step {
+		heights = append(heights, height)
+
+		if len(heights) >= locatorDenseHashes {
+			step *= 2
+		}
+	}
+
+	return append(heights, 0)
+}
+
+// BlockLocator returns the hashes of the main chain at the LocatorHeights of its tip
+func (bc *Blockchain) BlockLocator() [][]byte {
+	var locator [][]byte
+
+	for _, height := range LocatorHeights(bc.GetBestHeight()) {
+		hash, ok := bc.HashAtHeight(height)
+		if ok {
+			locator = append(locator, hash)
+		}
+	}
+
+	return locator
+}
+
+// HashAtHeight returns the hash of the block of the main chain at the height
+func (bc *Blockchain) HashAtHeight(height int) ([]byte, bool) {
+	var hash []byte
+
+	err := bc.db.View(func(tx *bbolt.Tx) error {
+		hash = tx.Bucket([]byte(heightsBucket)).Get(utils.IntToHex(int64(height)))
+		// bbolt only keeps the value valid during the transaction
+		hash = bytes.Clone(hash)
+
+		return nil
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hash, hash != nil
+}
+
+// MainChainHeight returns the height of the block when it is in the main chain
+func (bc *Blockchain) MainChainHeight(hash []byte) (int, bool) {
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return 0, false
+	}
+
+	indexed, ok := bc.HashAtHeight(block.Height())
+
+	return block.Height(), ok && bytes.Equal(indexed, hash)
+}
+
+// FindFork returns the height of the first block of the locator that is in the main chain
+// The genesis block is where every chain forks when none is
+func (bc *Blockchain) FindFork(locator [][]byte) int {
+	for _, hash := range locator {
+		if height, ok := bc.MainChainHeight(hash); ok {
+			return height
+		}
+	}
+
+	return 0
+}
+
+// GetBlockHashesAfter returns the hashes of the main chain following the fork point of the locator, lowest first
+// It stops after the block with the stop hash or after max hashes, whichever comes first
+func (bc *Blockchain) GetBlockHashesAfter(locator [][]byte, stopHash []byte, max int) [][]byte {
+	var hashes [][]byte
+
+	for height := bc.FindFork(locator) + 1; len(hashes) < max; height++ {
+		hash, ok := bc.HashAtHeight(height)
+		if !ok {
+			break
+		}
+
+		hashes = append(hashes, hash)
+
+		if bytes.Equal(hash, stopHash) {
+			break
+		}
+	}
+
+	return hashes
+}
+
+// GetHeaders returns the headers of the blocks GetBlockHashesAfter returns the hashes of
+func (bc *Blockchain) GetHeaders(locator [][]byte, stopHash []byte, max int) []*BlockHeader {
+	var headers []*BlockHeader
+
+	for _, hash := range bc.GetBlockHashesAfter(locator, stopHash, max) {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		headers = append(headers, block.Header())
+	}
+
+	return headers
+}
@@ -7,6 +7,7 @@ import (
 	"math"
 	"math/big"
 
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
 
@@ -14,20 +15,23 @@ var (
 	maxNonce = math.MaxInt64
 )
 
-const targetBits = 16
-
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
 // NewProofOfWork builds and returns a ProofOfWork
 func NewProofOfWork(block *Block) *ProofOfWork {
+	return NewHeaderProofOfWork(block.Header())
+}
+
+// NewHeaderProofOfWork builds and returns the ProofOfWork of a block known by its header only
+func NewHeaderProofOfWork(header *BlockHeader) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target.Lsh(target, uint(256-chaincfg.ActiveParams().TargetBits()))
 
-	pow := &ProofOfWork{block, target}
+	pow := &ProofOfWork{header, target}
 	return pow
 }
 
@@ -61,14 +65,15 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 }
 
 // Validate validates block's PoW
+// The hash the block carries has to be the one of its content as well
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce())
+	data := pow.prepareData(pow.header.Nonce())
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	isValid := hashInt.Cmp(pow.target) == -1
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Equal(hash[:], pow.header.Hash())
 
 	return isValid
 }
@@ -76,10 +81,10 @@ func (pow *ProofOfWork) Validate() bool {
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	data := bytes.Join(
 		[][]byte{
-			pow.block.PrevBlockHash(),
-			pow.block.HashTransactions(),
-			utils.IntToHex(pow.block.Timestamp()),
-			utils.IntToHex(int64(targetBits)),
+			pow.header.PrevBlockHash(),
+			pow.header.MerkleRoot(),
+			utils.IntToHex(pow.header.Timestamp()),
+			utils.IntToHex(int64(chaincfg.ActiveParams().TargetBits())),
 			utils.IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -0,0 +1,119 @@
+package chaincfg
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// paramsFile is the JSON representation of the parameters of a private network
+type paramsFile struct {
+	Name             string   `json:"name"`
+	PubKeyHashAddrID byte     `json:"pub_key_hash_addr_id"`
+	Bech32HRP        string   `json:"bech32_hrp"`
+	PrivateKeyID     byte     `json:"private_key_id"`
+	Magic            uint32   `json:"magic"`
+	DefaultPort      string   `json:"default_port"`
+	SeedNodes        []string `json:"seed_nodes"`
+	TargetBits       int      `json:"target_bits"`
+	Genesis          struct {
+		Message   string `json:"message"`
+		Timestamp int64  `json:"timestamp"`
+		Reward    int    `json:"reward"`
+		Address   string `json:"address"`
+		Hash      string `json:"hash"`
+	} `json:"genesis"`
+	Subsidy         int `json:"subsidy"`
+	HalvingInterval int `json:"halving_interval"`
+}
+
+// LoadParams reads the parameters of a private network from a JSON file
+// Seed nodes given without a port get the default port
+func LoadParams(path string) (*Params, error) {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file paramsFile
+	err = json.Unmarshal(fileContent, &file)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s is not a chain parameters file: %w", path, err)
+	}
+
+	err = file.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	genesisHash, err := hex.DecodeString(file.Genesis.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Genesis hash is not hex-encoded: %w", err)
+	}
+
+	if len(genesisHash) == 0 {
+		genesisHash = nil
+	}
+
+	var seedNodes []string
+	for _, node := range file.SeedNodes {
+		if _, _, err := net.SplitHostPort(node); err != nil {
+			node = net.JoinHostPort(node, file.DefaultPort)
+		}
+
+		seedNodes = append(seedNodes, node)
+	}
+
+	return &Params{
+		name:                file.Name,
+		pubKeyHashAddrID:    file.PubKeyHashAddrID,
+		bech32HRP:           file.Bech32HRP,
+		privateKeyID:        file.PrivateKeyID,
+		magic:               file.Magic,
+		defaultPort:         file.DefaultPort,
+		seedNodes:           seedNodes,
+		targetBits:          file.TargetBits,
+		genesisCoinbaseData: file.Genesis.Message,
+		genesisTimestamp:    file.Genesis.Timestamp,
+		genesisReward:       file.Genesis.Reward,
+		genesisAddress:      file.Genesis.Address,
+		genesisHash:         genesisHash,
+		subsidy:             file.Subsidy,
+		halvingInterval:     file.HalvingInterval,
+	}, nil
+}
+
+func (file *paramsFile) validate() error {
+	if _, err := ParamsFor(file.Name); file.Name == "" || err == nil {
+		return fmt.Errorf("ERROR: Network name %q is empty or taken by a built-in network", file.Name)
+	}
+
+	if file.Bech32HRP == "" {
+		return errors.New("ERROR: Bech32 prefix is missing")
+	}
+
+	if file.Magic == 0 {
+		return errors.New("ERROR: Magic number is missing")
+	}
+
+	if len(file.SeedNodes) == 0 {
+		return errors.New("ERROR: At least one seed node is needed")
+	}
+
+	if file.TargetBits < 1 || file.TargetBits > 255 {
+		return fmt.Errorf("ERROR: Target bits must be between 1 and 255, got %d", file.TargetBits)
+	}
+
+	if file.Genesis.Address == "" || file.Genesis.Message == "" || file.Genesis.Timestamp <= 0 || file.Genesis.Reward <= 0 {
+		return errors.New("ERROR: Genesis address, message, timestamp and reward are required")
+	}
+
+	if file.Subsidy <= 0 || file.HalvingInterval < 0 {
+		return errors.New("ERROR: Subsidy must be positive and the halving interval not negative")
+	}
+
+	return nil
+}
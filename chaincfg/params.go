@@ -0,0 +1,223 @@
+package chaincfg
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	MainNet = "mainnet"
+	TestNet = "testnet"
+	RegTest = "regtest"
+)
+
+// Params holds what tells one network apart from another
+// Addresses and private keys carry the version bytes or the human-readable prefix of their network, peers greet each other with its magic number
+// Nodes of the same network share the genesis block, so they refuse a chain starting from another one
+type Params struct {
+	name             string
+	pubKeyHashAddrID byte
+	bech32HRP        string
+	privateKeyID     byte
+	magic            uint32
+	defaultPort      string
+	seedNodes        []string
+	targetBits       int
+
+	genesisCoinbaseData string
+	genesisTimestamp    int64
+	genesisReward       int
+	// genesisAddress receives the genesis reward, the built-in networks pay it to an all-zero public key hash nobody can spend
+	genesisAddress string
+	// genesisHash is the expected hash of the genesis block, it is computed from the other genesis fields when empty
+	genesisHash []byte
+
+	subsidy         int
+	halvingInterval int
+}
+
+var (
+	MainNetParams = Params{
+		name:                MainNet,
+		bech32HRP:           "bc",
+		pubKeyHashAddrID:    0x00,
+		privateKeyID:        0x80,
+		magic:               0xd9b4bef9,
+		defaultPort:         "3000",
+		seedNodes:           []string{"localhost:3000"},
+		targetBits:          16,
+		genesisCoinbaseData: "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+		genesisTimestamp:    1231006505,
+		genesisReward:       10,
+		genesisAddress:      "1111111111111111111114oLvT2",
+		genesisHash:         mustDecodeHash("00004a426f50224b5c1a58192b664a38efdd85afa5c99624f47262fdd4dd05e2"),
+		subsidy:             10,
+		halvingInterval:     210000,
+	}
+
+	TestNetParams = Params{
+		name:                TestNet,
+		bech32HRP:           "tb",
+		pubKeyHashAddrID:    0x6f,
+		privateKeyID:        0xef,
+		magic:               0x0709110b,
+		defaultPort:         "13000",
+		seedNodes:           []string{"localhost:13000"},
+		targetBits:          16,
+		genesisCoinbaseData: "Test network genesis block",
+		genesisTimestamp:    1296688602,
+		genesisReward:       10,
+		genesisAddress:      "mfWxJ45yp2SFn7UciZyNpvDKrzbhyfKrY8",
+		genesisHash:         mustDecodeHash("0000c6975416d8b537413c5bdf550bd0cc65a9f2c7b2164871321fd7bf374b29"),
+		subsidy:             10,
+		halvingInterval:     210000,
+	}
+
+	RegTestParams = Params{
+		name:                RegTest,
+		bech32HRP:           "bcrt",
+		pubKeyHashAddrID:    0x6f,
+		privateKeyID:        0xef,
+		magic:               0xdab5bffa,
+		defaultPort:         "23000",
+		seedNodes:           []string{"localhost:23000"},
+		targetBits:          1,
+		genesisCoinbaseData: "Regression test network genesis block",
+		genesisTimestamp:    1296688602,
+		genesisReward:       10,
+		genesisAddress:      "mfWxJ45yp2SFn7UciZyNpvDKrzbhyfKrY8",
+		genesisHash:         mustDecodeHash("41fe18cd0810ab3aa0ef88e2180a599585eb228c8f51f40b738055da0d6d8359"),
+		subsidy:             10,
+		halvingInterval:     150,
+	}
+)
+
+var activeParams = &MainNetParams
+
+// ActiveParams returns the parameters of the network the node runs on
+func ActiveParams() *Params {
+	return activeParams
+}
+
+// SelectNetwork makes the named network the active one, an empty name selects mainnet
+func SelectNetwork(name string) error {
+	params, err := ParamsFor(name)
+	if err != nil {
+		return err
+	}
+
+	activeParams = params
+
+	return nil
+}
+
+// UseParams makes the parameters, such as ones loaded from a file, the active ones
+func UseParams(params *Params) {
+	activeParams = params
+}
+
+// Networks returns the parameters of every known network, a custom active network included
+func Networks() []*Params {
+	networks := []*Params{&MainNetParams, &TestNetParams, &RegTestParams}
+
+	for _, params := range networks {
+		if params == activeParams {
+			return networks
+		}
+	}
+
+	return append(networks, activeParams)
+}
+
+// ParamsFor returns the parameters of the named network
+func ParamsFor(name string) (*Params, error) {
+	switch strings.ToLower(name) {
+	case "", MainNet:
+		return &MainNetParams, nil
+	case TestNet:
+		return &TestNetParams, nil
+	case RegTest:
+		return &RegTestParams, nil
+	}
+
+	return nil, fmt.Errorf("ERROR: Unknown network %q, expected mainnet, testnet or regtest", name)
+}
+
+// mustDecodeHash decodes a hex-encoded hash of the built-in parameters
+func mustDecodeHash(hash string) []byte {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		panic(err)
+	}
+
+	return decoded
+}
+
+// Subsidy returns the reward of the block at the height, halved every halving interval
+func (p *Params) Subsidy(height int) int {
+	if height == 0 {
+		return p.genesisReward
+	}
+
+	if p.halvingInterval == 0 {
+		return p.subsidy
+	}
+
+	halvings := (height - 1) / p.halvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.subsidy >> halvings
+}
+
+func (p *Params) Name() string {
+	return p.name
+}
+
+func (p *Params) PubKeyHashAddrID() byte {
+	return p.pubKeyHashAddrID
+}
+
+func (p *Params) Bech32HRP() string {
+	return p.bech32HRP
+}
+
+func (p *Params) PrivateKeyID() byte {
+	return p.privateKeyID
+}
+
+func (p *Params) Magic() uint32 {
+	return p.magic
+}
+
+func (p *Params) DefaultPort() string {
+	return p.defaultPort
+}
+
+// SeedNodes returns the addresses of the nodes a new node first connects to
+func (p *Params) SeedNodes() []string {
+	return append([]string{}, p.seedNodes...)
+}
+
+// TargetBits returns the proof-of-work difficulty, regtest keeps it trivial so blocks are mined instantly
+func (p *Params) TargetBits() int {
+	return p.targetBits
+}
+
+func (p *Params) GenesisCoinbaseData() string {
+	return p.genesisCoinbaseData
+}
+
+func (p *Params) GenesisTimestamp() int64 {
+	return p.genesisTimestamp
+}
+
+func (p *Params) GenesisAddress() string {
+	return p.genesisAddress
+}
+
+func (p *Params) GenesisHash() []byte {
+	return p.genesisHash
+}
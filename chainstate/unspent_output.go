@@ -0,0 +1,35 @@
+package chainstate
+
+import "github.com/lugassawan/learning-golang-blockchain/transaction"
+
+// UnspentOutput represents an unspent output together with the outpoint referencing it
+type UnspentOutput struct {
+	txId   []byte
+	index  int
+	output transaction.TXOutput
+}
+
+// NewUnspentOutput creates a new UnspentOutput
+func NewUnspentOutput(txId []byte, index int, output transaction.TXOutput) *UnspentOutput {
+	return &UnspentOutput{txId, index, output}
+}
+
+func (uo *UnspentOutput) TxId() []byte {
+	return uo.txId
+}
+
+func (uo *UnspentOutput) Index() int {
+	return uo.index
+}
+
+func (uo *UnspentOutput) Output() transaction.TXOutput {
+	return uo.output
+}
+
+func (uo *UnspentOutput) Value() int {
+	return uo.output.Value()
+}
+
+func (uo *UnspentOutput) PubKeyHash() []byte {
+	return uo.output.PubKeyHash()
+}
@@ -1,7 +1,9 @@
 package chainstate
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
@@ -57,6 +59,36 @@ func (utx *UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, ma
 	return accumulated, unspentOutputs
 }
 
+// FindUnspentOutputs returns every unspent output locked with the public key hash
+func (utx *UTXOSet) FindUnspentOutputs(pubKeyHash []byte) []UnspentOutput {
+	var unspentOutputs []UnspentOutput
+	db := utx.blockchain.GetDB()
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := transaction.DeserializeOutputs(v)
+			txID := append([]byte{}, k...)
+
+			for outIdx, out := range outs.Outputs() {
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspentOutputs = append(unspentOutputs, *NewUnspentOutput(txID, outIdx, out))
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return unspentOutputs
+}
+
 // FindUTXO finds UTXO for a public key hash
 func (utx *UTXOSet) FindUTXO(pubKeyHash []byte) []transaction.TXOutput {
 	var UTXOs []transaction.TXOutput
@@ -109,6 +141,196 @@ func (utx *UTXOSet) CountTransactions() int {
 	return counter
 }
 
+// CheckBlock checks the transactions of a block following the tip before the block is added
+func (utx *UTXOSet) CheckBlock(block *blockchain.Block) error {
+	err := block.CheckTransactions()
+	if err != nil {
+		return err
+	}
+
+	return utx.CheckSpends(block.Transactions())
+}
+
+// CheckSpends checks transactions to be mined together against the UTXO set: valid signatures, outputs still unspent
+// and spent once, and no transaction paying more than it spends
+func (utx *UTXOSet) CheckSpends(txs []*transaction.Transaction) error {
+	return checkSpends(txs, utx.blockchain.FindTransaction, utx.unspentOutputs)
+}
+
+// CheckBranch checks the blocks of a branch about to become the main chain, lowest first
+// The UTXO set is the one of the tip, the branch is checked against the unspent outputs of the block it leaves the main
+// chain from instead, updated block after block
+func (utx *UTXOSet) CheckBranch(blocks []*blockchain.Block) error {
+	utxo := utx.blockchain.FindUTXOAt(blocks[0].PrevBlockHash())
+	branchTxs := make(map[string]transaction.Transaction)
+
+	// The main chain has the transactions before the fork, the ones past it only spend outputs missing from utxo
+	findTransaction := func(id []byte) (transaction.Transaction, error) {
+		if tx, ok := branchTxs[hex.EncodeToString(id)]; ok {
+			return tx, nil
+		}
+
+		return utx.blockchain.FindTransaction(id)
+	}
+
+	unspentOutputs := func(txID string) []transaction.TXOutput {
+		outs := utxo[txID]
+
+		return outs.Outputs()
+	}
+
+	for _, block := range blocks {
+		err := block.CheckTransactions()
+		if err == nil {
+			err = checkSpends(block.Transactions(), findTransaction, unspentOutputs)
+		}
+
+		if err != nil && block != blocks[len(blocks)-1] {
+			return fmt.Errorf("block %x of its branch is invalid, %w", block.Hash(), err)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range block.Transactions() {
+			txID := hex.EncodeToString(tx.ID())
+
+			for _, vin := range tx.Vin() {
+				if tx.IsCoinbase() {
+					break
+				}
+
+				prevTx, _ := findTransaction(vin.TxId())
+				prevTxID := hex.EncodeToString(vin.TxId())
+				utxo[prevTxID] = spendOutput(utxo[prevTxID], prevTx.Vout()[vin.Vout()])
+			}
+
+			outs := transaction.TXOutputs{}
+			for _, out := range tx.Vout() {
+				outs.Add(out)
+			}
+
+			utxo[txID] = outs
+			branchTxs[txID] = *tx
+		}
+	}
+
+	return nil
+}
+
+// checkSpends checks the transactions against the outputs unspentOutputs returns, findTransaction finds the transactions
+// they spend from
+func checkSpends(txs []*transaction.Transaction, findTransaction func(id []byte) (transaction.Transaction, error), unspentOutputs func(txID string) []transaction.TXOutput) error {
+	// spends counts the outputs of each previous transaction spent by the transactions, by outpoint and by output
+	// The set keeps the unspent outputs of a transaction without their index, they are told apart by their content
+	spends := make(map[string]int)
+	spent := make(map[string][]transaction.TXOutput)
+
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		prevTxs := make(map[string]transaction.Transaction)
+		for _, vin := range tx.Vin() {
+			prevTx, err := findTransaction(vin.TxId())
+			if err != nil {
+				return fmt.Errorf("transaction %x spends from an unknown transaction", tx.ID())
+			}
+
+			prevTxs[hex.EncodeToString(vin.TxId())] = prevTx
+		}
+
+		if !tx.Verify(prevTxs) {
+			return fmt.Errorf("transaction %x is not signed by the owners of the outputs it spends", tx.ID())
+		}
+
+		input := 0
+		for _, vin := range tx.Vin() {
+			outpoint := fmt.Sprintf("%x:%d", vin.TxId(), vin.Vout())
+			spends[outpoint]++
+			if spends[outpoint] > 1 {
+				return fmt.Errorf("transaction %x spends %s again", tx.ID(), outpoint)
+			}
+
+			prevTxID := hex.EncodeToString(vin.TxId())
+			prevTx := prevTxs[prevTxID]
+			out := prevTx.Vout()[vin.Vout()]
+			spent[prevTxID] = append(spent[prevTxID], out)
+			input += out.Value()
+		}
+
+		output := 0
+		for _, out := range tx.Vout() {
+			output += out.Value()
+		}
+
+		if output > input {
+			return fmt.Errorf("transaction %x pays %d out of %d", tx.ID(), output, input)
+		}
+	}
+
+	for prevTxID, outs := range spent {
+		unspent := transaction.TXOutputs{}
+		for _, out := range unspentOutputs(prevTxID) {
+			unspent.Add(out)
+		}
+
+		for _, out := range outs {
+			left := spendOutput(unspent, out)
+			if len(left.Outputs()) == len(unspent.Outputs()) {
+				return fmt.Errorf("an output of %s is already spent", prevTxID)
+			}
+
+			unspent = left
+		}
+	}
+
+	return nil
+}
+
+// spendOutput returns the outputs without the first one with the value and the public key hash of the spent output
+func spendOutput(outs transaction.TXOutputs, spent transaction.TXOutput) transaction.TXOutputs {
+	left := transaction.TXOutputs{}
+	found := false
+
+	for _, out := range outs.Outputs() {
+		if !found && out.Value() == spent.Value() && bytes.Equal(out.PubKeyHash(), spent.PubKeyHash()) {
+			found = true
+			continue
+		}
+
+		left.Add(out)
+	}
+
+	return left
+}
+
+// unspentOutputs returns the unspent outputs of the transaction with the hex-encoded ID in the UTXO set
+func (utx *UTXOSet) unspentOutputs(txID string) []transaction.TXOutput {
+	var outs transaction.TXOutputs
+
+	key, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = utx.blockchain.GetDB().View(func(tx *bbolt.Tx) error {
+		if data := tx.Bucket([]byte(utxoBucket)).Get(key); data != nil {
+			outs = transaction.DeserializeOutputs(data)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outs.Outputs()
+}
+
 // Reindex rebuilds the UTXO set
 func (utx *UTXOSet) Reindex() {
 	db := utx.blockchain.GetDB()
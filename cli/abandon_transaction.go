@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) abandonTransaction(txID, nodeID string) {
+	history, err := wallet.NewHistory(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = history.AbandonPending(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history.SaveToFile(nodeID)
+
+	fmt.Printf("Abandoned transaction %s\n", txID)
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/lugassawan/learning-golang-blockchain/server"
+)
+
+func (cli *CLI) addPeer(address, nodeID string) {
+	_, _, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Panic("ERROR: Peer address must be HOST:PORT")
+	}
+
+	book, err := server.NewAddrBook(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !book.Add(address, server.SourceManual) {
+		fmt.Printf("%s is already known\n", address)
+		return
+	}
+
+	book.SaveToFile(nodeID)
+
+	fmt.Printf("Added peer %s\n", address)
+}
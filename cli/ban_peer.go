@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/server"
+)
+
+// banPeer bans the host of the address, peers are banned by the IP address they connect from
+// A host name bans every IP address it resolves to. On a loopback or private network, where nodes share their IP
+// address, the address with its port is banned instead
+func (cli *CLI) banPeer(address string, duration time.Duration, reason, nodeID string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	hosts := []string{host}
+	if host != "localhost" && net.ParseIP(host) == nil {
+		hosts, err = net.LookupHost(host)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	book, err := server.NewAddrBook(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, host := range hosts {
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		book.Ban(host, time.Now().Add(duration), reason)
+
+		if duration <= 0 {
+			fmt.Printf("Lifted the ban of %s\n", host)
+		} else {
+			fmt.Printf("Banned %s for %s\n", host, duration)
+		}
+	}
+
+	book.SaveToFile(nodeID)
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) changePassphrase(oldPassphrase, newPassphrase, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	oldPassphrase = readPassphrase(oldPassphrase, "Current wallet passphrase")
+	newPassphrase = readPassphrase(newPassphrase, "New wallet passphrase")
+
+	err = wallets.ChangePassphrase(oldPassphrase, newPassphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Passphrase changed!")
+}
@@ -5,90 +5,257 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/lugassawan/learning-golang-blockchain/config"
 	"github.com/lugassawan/learning-golang-blockchain/server"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
 type CLI struct {
-	svc *server.Server
+	svc  *server.Server
+	cfg  *config.Config
+	args []string
 }
 
 // InitCLI create CLI instance
+// The global flags before the command configure the node, together with the configuration file and env. vars
 func InitCLI() CLI {
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	return CLI{server.InitServer(nodeID)}
+	return CLI{server.InitServer(cfg), cfg, args}
 }
 
 // Run parses command line arguments and processes commands
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
-	nodeID := os.Getenv("NODE_ID")
-	if nodeID == "" {
-		fmt.Printf("NODE_ID env. var is not set!")
-		os.Exit(1)
-	}
+	nodeID := cli.cfg.NodeID
 
+	abandonTransactionCmd := flag.NewFlagSet("abandon_transaction", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("get_balance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("create_blockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("create_wallet", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encrypt_wallet", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dump_privkey", flag.ExitOnError)
+	exportMnemonicCmd := flag.NewFlagSet("export_mnemonic", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("import_privkey", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("import_address", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restore_wallet", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("change_passphrase", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("list_addresses", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("list_transactions", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("print_chain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindex_utxo", flag.ExitOnError)
+	rescanCmd := flag.NewFlagSet("rescan", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	syncLightCmd := flag.NewFlagSet("sync_light", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("create_psbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("sign_psbt", flag.ExitOnError)
+	combinePSBTCmd := flag.NewFlagSet("combine_psbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalize_psbt", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("start_node", flag.ExitOnError)
+	listPeersCmd := flag.NewFlagSet("list_peers", flag.ExitOnError)
+	addPeerCmd := flag.NewFlagSet("add_peer", flag.ExitOnError)
+	banPeerCmd := flag.NewFlagSet("ban_peer", flag.ExitOnError)
 
+	abandonTransactionTxID := abandonTransactionCmd.String("txid", "", "ID of the pending transaction")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address the first block after the genesis block pays")
+	createWalletHD := createWalletCmd.Bool("hd", false, "Derive addresses from a seed backed up by a mnemonic phrase")
+	createWalletPath := createWalletCmd.String("path", "", "Derive the address at this path, such as m/44'/0'/0'/0/5")
+	createWalletPassphrase := createWalletCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "The address to export the private key of")
+	dumpPrivKeyPassphrase := dumpPrivKeyCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "Private key in WIF format")
+	importPrivKeyPassphrase := importPrivKeyCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	exportMnemonicPassphrase := exportMnemonicCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic phrase of the seed to restore")
+	restoreWalletGap := restoreWalletCmd.Int("gap", wallet.DefaultGapLimit, "Stop after this many consecutive unused addresses")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	generateN := generateCmd.Int("n", 1, "Number of blocks to mine")
+	generateTo := generateCmd.String("to", "", "The address to send the block rewards to")
+	generateTimestamp := generateCmd.Int64("timestamp", 0, "Unix timestamp of the first block, the next ones follow a second apart. The current time when omitted")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "New wallet passphrase, prompted for when empty")
+	changePassphraseOld := changePassphraseCmd.String("old", "", "Current wallet passphrase, prompted for when empty")
+	changePassphraseNew := changePassphraseCmd.String("new", "", "New wallet passphrase, prompted for when empty")
+	listAddressesBech32 := listAddressesCmd.Bool("bech32", false, "Show the addresses in the Bech32 format")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Only list transactions touching this wallet address")
+	listTransactionsLimit := listTransactionsCmd.Int("limit", 10, "Number of most recent transactions to list, 0 lists all of them")
+	rescanFromHeight := rescanCmd.Int("from_height", -1, "Rebuild the wallet history from this block height, resumes an interrupted rescan when omitted")
+	sendFrom := sendCmd.String("from", "", "Source wallet address, or a comma-separated list of addresses")
+	sendAccount := sendCmd.Bool("account", false, "Spend from every address in the wallet file")
+	sendTo := &recipientsFlag{}
+	sendCmd.Var(sendTo, "to", "Destination ADDRESS:AMOUNT, may be repeated. A bare ADDRESS receives -amount")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFile := sendCmd.String("file", "", "CSV or JSON file with the recipients")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendStrategy := sendCmd.String("strategy", wallet.StrategyBranchAndBound, "Coin selection strategy: bnb, largest, smallest or random")
+	sendFeeRate := sendCmd.Int("fee_rate", 0, "Fee rate in coins per 1000 bytes")
+	sendPassphrase := sendCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	sendUnlockTimeout := sendCmd.Duration("unlock_timeout", time.Minute, "Lock the wallet again after this long")
+	sendSpendUnconfirmed := sendCmd.Bool("spend_unconfirmed", false, "Also spend the change of transactions that are not confirmed yet")
+	createPSBTFrom := createPSBTCmd.String("from", "", "Source wallet address, or a comma-separated list of addresses. Watch-only addresses are allowed")
+	createPSBTTo := &recipientsFlag{}
+	createPSBTCmd.Var(createPSBTTo, "to", "Destination ADDRESS:AMOUNT, may be repeated. A bare ADDRESS receives -amount")
+	createPSBTAmount := createPSBTCmd.Int("amount", 0, "Amount to send")
+	createPSBTFile := createPSBTCmd.String("file", "", "CSV or JSON file with the recipients")
+	createPSBTStrategy := createPSBTCmd.String("strategy", wallet.StrategyBranchAndBound, "Coin selection strategy: bnb, largest, smallest or random")
+	createPSBTFeeRate := createPSBTCmd.Int("fee_rate", 0, "Fee rate in coins per 1000 bytes")
+	createPSBTSpendUnconfirmed := createPSBTCmd.Bool("spend_unconfirmed", false, "Also spend the change of transactions that are not confirmed yet")
+	createPSBTOut := createPSBTCmd.String("out", "", "File to write the unsigned transaction to")
+	signPSBTIn := signPSBTCmd.String("in", "", "File with the transaction to sign")
+	signPSBTOut := signPSBTCmd.String("out", "", "File to write the signed transaction to, -in when empty")
+	signPSBTPassphrase := signPSBTCmd.String("passphrase", "", "Passphrase of an encrypted wallet, prompted for when empty")
+	combinePSBTIn := combinePSBTCmd.String("in", "", "Comma-separated list of files with copies of the transaction signed separately")
+	combinePSBTOut := combinePSBTCmd.String("out", "", "File to write the combined transaction to")
+	finalizePSBTIn := finalizePSBTCmd.String("in", "", "File with the fully signed transaction")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	addPeerAddress := addPeerCmd.String("address", "", "HOST:PORT of the peer")
+	banPeerAddress := banPeerCmd.String("address", "", "IP address or HOST:PORT of the peer, its host is banned")
+	banPeerDuration := banPeerCmd.Duration("duration", cli.cfg.BanDuration.Duration, "How long to ban the peer for, 0 lifts the ban")
+	banPeerReason := banPeerCmd.String("reason", "banned by the user", "Why the peer is banned")
 
-	switch os.Args[1] {
+	switch cli.args[0] {
+	case "abandon_transaction":
+		err := abandonTransactionCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "get_balance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "create_blockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "create_wallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encrypt_wallet":
+		err := encryptWalletCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "change_passphrase":
+		err := changePassphraseCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dump_privkey":
+		err := dumpPrivKeyCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "import_privkey":
+		err := importPrivKeyCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "import_address":
+		err := importAddressCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "export_mnemonic":
+		err := exportMnemonicCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restore_wallet":
+		err := restoreWalletCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "list_addresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "list_transactions":
+		err := listTransactionsCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "print_chain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "reindex_utxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "rescan":
+		err := rescanCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sync_light":
+		err := syncLightCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "create_psbt":
+		err := createPSBTCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sign_psbt":
+		err := signPSBTCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "combine_psbt":
+		err := combinePSBTCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalize_psbt":
+		err := finalizePSBTCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "start_node":
-		err := startNodeCmd.Parse(os.Args[2:])
+		err := startNodeCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "list_peers":
+		err := listPeersCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "add_peer":
+		err := addPeerCmd.Parse(cli.args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "ban_peer":
+		err := banPeerCmd.Parse(cli.args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -97,28 +264,88 @@ func (cli *CLI) Run() {
 		os.Exit(1)
 	}
 
+	if abandonTransactionCmd.Parsed() {
+		if *abandonTransactionTxID == "" {
+			abandonTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.abandonTransaction(*abandonTransactionTxID, nodeID)
+	}
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			os.Exit(1)
+			cli.getWalletBalance(nodeID)
+		} else {
+			cli.getBalance(*getBalanceAddress, nodeID)
 		}
-		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
+		cli.createBlockchain(*createBlockchainAddress, nodeID)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateTo == "" {
+			generateCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		cli.generate(*generateN, *generateTo, *generateTimestamp, nodeID)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		cli.createWallet(*createWalletHD, *createWalletPath, *createWalletPassphrase, nodeID)
+	}
+
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(*dumpPrivKeyAddress, *dumpPrivKeyPassphrase, nodeID)
+	}
+
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(*importPrivKeyKey, *importPrivKeyPassphrase, nodeID)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(*importAddressAddress, nodeID)
+	}
+
+	if exportMnemonicCmd.Parsed() {
+		cli.exportMnemonic(*exportMnemonicPassphrase, nodeID)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletGap, *restoreWalletPassphrase, nodeID)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		cli.encryptWallet(*encryptWalletPassphrase, nodeID)
+	}
+
+	if changePassphraseCmd.Parsed() {
+		cli.changePassphrase(*changePassphraseOld, *changePassphraseNew, nodeID)
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(*listAddressesBech32, nodeID)
+	}
+
+	if listTransactionsCmd.Parsed() {
+		cli.listTransactions(*listTransactionsAddress, *listTransactionsLimit, nodeID)
 	}
 
 	if printChainCmd.Parsed() {
@@ -129,40 +356,154 @@ func (cli *CLI) Run() {
 		cli.reindexUTXO(nodeID)
 	}
 
+	if rescanCmd.Parsed() {
+		cli.rescan(*rescanFromHeight, nodeID)
+	}
+
+	if syncLightCmd.Parsed() {
+		cli.syncLight(nodeID)
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if (*sendFrom == "" && !*sendAccount) || (len(*sendTo) == 0 && *sendFile == "") {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		// A block mined on the spot can't hold a transaction whose parent is still pending
+		if *sendMine && *sendSpendUnconfirmed {
+			fmt.Println("ERROR: -spend_unconfirmed can't be used with -mine, the change it may spend is not in a block yet")
+			os.Exit(1)
+		}
+
+		recipients, err := buildRecipients(*sendTo, *sendAmount, *sendFile)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		var from []string
+		if *sendFrom != "" {
+			from = strings.Split(*sendFrom, ",")
+		}
+
+		cli.send(from, *sendAccount, recipients, *sendFeeRate, *sendStrategy, *sendPassphrase, *sendUnlockTimeout, *sendSpendUnconfirmed, nodeID, *sendMine)
+	}
+
+	if createPSBTCmd.Parsed() {
+		if *createPSBTFrom == "" || *createPSBTOut == "" {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+
+		recipients, err := buildRecipients(*createPSBTTo, *createPSBTAmount, *createPSBTFile)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		cli.createPSBT(strings.Split(*createPSBTFrom, ","), recipients, *createPSBTFeeRate, *createPSBTStrategy, *createPSBTSpendUnconfirmed, *createPSBTOut, nodeID)
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTIn == "" {
+			signPSBTCmd.Usage()
+			os.Exit(1)
+		}
+
+		out := *signPSBTOut
+		if out == "" {
+			out = *signPSBTIn
+		}
+
+		cli.signPSBT(*signPSBTIn, out, *signPSBTPassphrase, nodeID)
+	}
+
+	if combinePSBTCmd.Parsed() {
+		if *combinePSBTIn == "" || *combinePSBTOut == "" {
+			combinePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combinePSBT(strings.Split(*combinePSBTIn, ","), *combinePSBTOut)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTIn == "" {
+			finalizePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizePSBT(*finalizePSBTIn, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
-		nodeID := os.Getenv("NODE_ID")
-		if nodeID == "" {
-			startNodeCmd.Usage()
+		minerAddress := *startNodeMiner
+		if minerAddress == "" {
+			minerAddress = cli.cfg.MiningAddress
+		}
+		cli.startNode(nodeID, minerAddress)
+	}
+
+	if listPeersCmd.Parsed() {
+		cli.listPeers(nodeID)
+	}
+
+	if addPeerCmd.Parsed() {
+		if *addPeerAddress == "" {
+			addPeerCmd.Usage()
+			os.Exit(1)
+		}
+		cli.addPeer(*addPeerAddress, nodeID)
+	}
+
+	if banPeerCmd.Parsed() {
+		if *banPeerAddress == "" {
+			banPeerCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		cli.banPeer(*banPeerAddress, *banPeerDuration, *banPeerReason, nodeID)
 	}
 }
 
 func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+	if len(cli.args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
 }
 
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: [GLOBAL FLAGS] COMMAND [FLAGS]")
+	fmt.Println("  Global flags override the settings of the -config JSON file and the env. vars, pass -h to list them")
+	fmt.Println("  The NODE_ID env. var selects the node, the NETWORK env. var selects mainnet (default), testnet or regtest")
+	fmt.Println("  The CHAIN_PARAMS env. var names a JSON file with the parameters of a private network, it takes precedence over NETWORK")
 	fmt.Println("  print_chain - Print all the blocks of the blockchain")
-	fmt.Println("  list_addresses - Lists all addresses from the wallet file")
-	fmt.Println("  create_wallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  create_blockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  get_balance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  list_addresses [-bech32] - Lists all addresses from the wallet file, watch-only ones included")
+	fmt.Println("  list_transactions -address ADDRESS -limit N - Lists the N most recent wallet transactions, of ADDRESS only when given")
+	fmt.Println("  create_wallet -hd -path PATH -passphrase PASSPHRASE - Generates a new key-pair and saves it into the wallet file. -hd derives keys from a seed, -path derives the key at PATH")
+	fmt.Println("  restore_wallet -mnemonic PHRASE -gap N - Restores a seed from its mnemonic phrase and rediscovers used addresses")
+	fmt.Println("  export_mnemonic - Prints the mnemonic phrase of the wallet seed")
+	fmt.Println("  dump_privkey -address ADDRESS - Prints the private key of ADDRESS in WIF format")
+	fmt.Println("  import_privkey -key WIF - Adds a private key in WIF format to the wallet file")
+	fmt.Println("  import_address -address ADDRESS - Watches ADDRESS without holding its private key")
+	fmt.Println("  encrypt_wallet -passphrase PASSPHRASE - Encrypts the private keys of the wallet file")
+	fmt.Println("  change_passphrase -old OLD -new NEW - Changes the passphrase of an encrypted wallet file")
+	fmt.Println("  abandon_transaction -txid TXID - Forgets a pending transaction that will never confirm and unlocks the coins it spends")
+	fmt.Println("  create_blockchain -address ADDRESS - Create a blockchain starting from the genesis block of the network. With ADDRESS, mine a first block paying it")
+	fmt.Println("  get_balance -address ADDRESS - Get balance of ADDRESS, or of every wallet address when omitted")
+	fmt.Println("  generate -n N -to ADDRESS -timestamp UNIX - Mines N blocks paying ADDRESS at once, regtest only. -timestamp stamps the first block")
 	fmt.Println("  reindex_utxo - Rebuilds the UTXO set")
+	fmt.Println("  rescan -from_height HEIGHT - Rebuilds the wallet history from block HEIGHT for every wallet address, resumes an interrupted rescan without HEIGHT")
+	fmt.Println("  sync_light - Syncs the block headers and the proven transactions of the wallet addresses from a full node, then prints their balances. No blockchain needed")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  start_node -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("       -from FROM1,FROM2 -account - Spend from several addresses, or from every address with -account. The change goes to a new address")
+	fmt.Println("       -to ADDRESS:AMOUNT (repeatable) -file RECIPIENTS - Pay many recipients in one transaction, from flags or a CSV/JSON file")
+	fmt.Println("       -passphrase PASSPHRASE -unlock_timeout DURATION - Unlock an encrypted wallet for DURATION while signing")
+	fmt.Println("       -strategy STRATEGY -fee_rate RATE - Select coins with bnb (default), largest, smallest or random, paying RATE coins per 1000 bytes")
+	fmt.Println("       -spend_unconfirmed - Also spend the change of sent transactions that are still pending, not with -mine")
+	fmt.Println("  create_psbt -from FROM -to TO -amount AMOUNT -out FILE - Writes an unsigned transaction to FILE, takes the -file, -strategy, -fee_rate and -spend_unconfirmed flags of send")
+	fmt.Println("  sign_psbt -in FILE -out FILE - Signs the inputs of the wallet addresses. Only needs the wallet file, so it works offline")
+	fmt.Println("  combine_psbt -in FILE1,FILE2 -out FILE - Merges copies of a transaction signed separately")
+	fmt.Println("  finalize_psbt -in FILE - Checks every signature of the transaction and broadcasts it")
+	fmt.Println("  start_node -miner ADDRESS - Start the node on its listen address. -miner enables mining, the mining_address setting by default")
+	fmt.Println("  list_peers - Lists the address book of the node with the tries, successes, misbehaviour score and ban of every peer")
+	fmt.Println("  add_peer -address HOST:PORT - Adds a peer to the address book, a running node dials it when it needs more peers")
+	fmt.Println("  ban_peer -address ADDRESS -duration DURATION -reason REASON - Bans the host of a peer, only the peer on a loopback or private network, the ban_duration setting by default. -duration 0 lifts the ban")
 }
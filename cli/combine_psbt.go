@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) combinePSBT(in []string, out string) {
+	var psts []*wallet.PartiallySignedTransaction
+
+	for _, path := range in {
+		pst, err := wallet.LoadPartiallySignedTransaction(path)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		psts = append(psts, pst)
+	}
+
+	err := psts[0].Combine(psts[1:]...)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	psts[0].SaveToFile(out)
+
+	fmt.Println(psts[0])
+	fmt.Printf("Combined transaction written to %s\n", out)
+}
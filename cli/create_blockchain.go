@@ -6,17 +6,33 @@ import (
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
 
+// createBlockchain creates the chain from the genesis block of the active network
+// The genesis reward can't be spent, so with an address the first block is mined on top of it paying the address.
+// This funds a local chain on every network, generate only works on regtest
 func (cli *CLI) createBlockchain(address, nodeID string) {
-	if !utils.ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+	if address != "" {
+		_, err := utils.DecodeAddress(address)
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
-	bc := blockchain.CreateBlockchain(address, nodeID)
+	bc := blockchain.CreateBlockchain(nodeID)
 	defer bc.Close()
 
+	hashes := bc.GetBlockHashes()
+	fmt.Printf("Genesis block: %x\n", hashes[len(hashes)-1])
+
+	if address != "" {
+		cbTx := transaction.NewCoinbaseTX(address, "", 1)
+		block := bc.MineBlock([]*transaction.Transaction{cbTx})
+		fmt.Printf("Block %x pays %s\n", block.Hash(), address)
+	}
+
 	UTXOSet := chainstate.NewUTXOSet(bc)
 	UTXOSet.Reindex()
 
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) createPSBT(from []string, recipients []wallet.Recipient, feeRate int, strategy string, spendUnconfirmed bool, out, nodeID string) {
+	for _, address := range from {
+		if !utils.ValidateAddress(address) {
+			log.Panicf("ERROR: Sender address %s is not valid", address)
+		}
+	}
+
+	selector, err := wallet.NewCoinSelector(strategy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := blockchain.NewBlockchain(nodeID)
+	UTXOSet := chainstate.NewUTXOSet(bc)
+	defer bc.Close()
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history, err := wallet.NewHistory(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history.Sync(bc, wallets)
+	history.SaveToFile(nodeID)
+
+	pst, selection := wallets.CreatePartiallySignedTransaction(from, recipients, feeRate, selector, UTXOSet, history, spendUnconfirmed)
+	pst.SaveToFile(out)
+
+	fmt.Println(selection)
+	fmt.Println(pst)
+	fmt.Printf("Unsigned transaction written to %s\n", out)
+}
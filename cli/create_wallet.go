@@ -2,13 +2,38 @@ package cli
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
-func (cli *CLI) createWallet(nodeID string) {
+func (cli *CLI) createWallet(hd bool, path, passphrase, nodeID string) {
 	wallets, _ := wallet.NewWallets(nodeID)
-	address := wallets.CreateWallet()
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	if (hd || path != "") && !wallets.IsHD() {
+		mnemonic, err := wallets.InitHDChain(nil)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Println("Write down your recovery phrase, it restores every address of this wallet:")
+		fmt.Printf("  %s\n\n", mnemonic)
+	}
+
+	var address string
+	if path != "" {
+		var err error
+
+		address, err = wallets.DeriveWallet(path)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		address = wallets.CreateWallet()
+	}
+
 	wallets.SaveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
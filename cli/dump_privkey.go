@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) dumpPrivKey(address, passphrase, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	wif, err := wallets.DumpPrivateKey(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(wif)
+}
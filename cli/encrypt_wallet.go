@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) encryptWallet(passphrase, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	passphrase = readPassphrase(passphrase, "New wallet passphrase")
+
+	err = wallets.Encrypt(passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets.SaveToFile(nodeID)
+
+	fmt.Println("Wallet encrypted. Commands that sign will ask for the passphrase.")
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) exportMnemonic(passphrase, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	mnemonic, err := wallets.Mnemonic()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(mnemonic)
+}
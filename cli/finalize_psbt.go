@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) finalizePSBT(in, nodeID string) {
+	pst, err := wallet.LoadPartiallySignedTransaction(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := pst.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history, err := wallet.NewHistory(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = cli.svc.SendTx(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history.AddPending(tx)
+	history.SaveToFile(nodeID)
+
+	fmt.Printf("Broadcast transaction %x\n", tx.ID())
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+// generate mines n blocks paying their reward to the address, only on regtest where the difficulty is trivial
+// A timestamp stamps the first block and every next one a second later, so the resulting chain is the same on every run
+func (cli *CLI) generate(n int, address string, timestamp int64, nodeID string) {
+	if chaincfg.ActiveParams().Name() != chaincfg.RegTest {
+		log.Panic("ERROR: generate is only available on regtest")
+	}
+
+	if n <= 0 {
+		log.Panic("ERROR: Number of blocks must be positive")
+	}
+
+	_, err := utils.DecodeAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := blockchain.NewBlockchain(nodeID)
+	defer bc.Close()
+
+	UTXOSet := chainstate.NewUTXOSet(bc)
+
+	for i := 0; i < n; i++ {
+		height := bc.GetBestHeight() + 1
+
+		blockTime := time.Now().Unix()
+		if timestamp > 0 {
+			blockTime = timestamp + int64(i)
+		}
+
+		// The height keeps coinbase transactions paying the same address unique
+		cbTx := transaction.NewCoinbaseTX(address, fmt.Sprintf("Regtest block %d", height), height)
+
+		newBlock := bc.MineBlockWithTimestamp([]*transaction.Transaction{cbTx}, blockTime)
+		UTXOSet.Update(newBlock)
+
+		fmt.Printf("%d %x\n", newBlock.Height(), newBlock.Hash())
+	}
+}
@@ -3,30 +3,98 @@ package cli
 import (
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/chainstate"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
 func (cli *CLI) getBalance(address, nodeID string) {
-	if !utils.ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+	pubKeyHash, err := utils.DecodeAddress(address)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	bc := blockchain.NewBlockchain(nodeID)
 	defer bc.Close()
 
 	UTXOSet := chainstate.NewUTXOSet(bc)
+	history := syncHistory(bc, nodeID)
 
+	balance := addressBalance(pubKeyHash, UTXOSet)
+	pending := history.PendingBalance(UTXOSet, pubKeyHash)
+
+	fmt.Printf("Balance of '%s': %d (pending: %+d)\n", address, balance, pending)
+}
+
+// getWalletBalance prints the balance of every address in the wallet file, watch-only ones included
+// Pending amounts come from the transactions the wallet sent that are not confirmed yet
+func (cli *CLI) getWalletBalance(nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := blockchain.NewBlockchain(nodeID)
+	defer bc.Close()
+
+	UTXOSet := chainstate.NewUTXOSet(bc)
+	history := syncHistory(bc, nodeID)
+
+	addresses := wallets.GetAddresses()
+	sort.Strings(addresses)
+
+	total := 0
+	totalPending := 0
+	watchOnly := 0
+
+	for _, address := range addresses {
+		wallet := wallets.GetWallet(address)
+		balance := addressBalance(wallet.GetPubKeyHash(), UTXOSet)
+		pending := history.PendingBalance(UTXOSet, wallet.GetPubKeyHash())
+
+		if wallet.IsWatchOnly() {
+			watchOnly += balance
+			fmt.Printf("Balance of '%s' (watch-only): %d (pending: %+d)\n", address, balance, pending)
+		} else {
+			total += balance
+			totalPending += pending
+			fmt.Printf("Balance of '%s': %d (pending: %+d)\n", address, balance, pending)
+		}
+	}
+
+	fmt.Printf("Total balance: %d\n", total)
+	fmt.Printf("Pending balance: %d\n", total+totalPending)
+	fmt.Printf("Watch-only balance: %d\n", watchOnly)
+}
+
+// syncHistory loads the wallet history and drops the pending transactions confirmed since it was saved
+func syncHistory(bc *blockchain.Blockchain, nodeID string) *wallet.History {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history, err := wallet.NewHistory(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history.Sync(bc, wallets)
+	history.SaveToFile(nodeID)
+
+	return history
+}
+
+func addressBalance(pubKeyHash []byte, UTXOSet *chainstate.UTXOSet) int {
 	balance := 0
-	pubKeyHash := utils.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
 	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
 
 	for _, out := range UTXOs {
 		balance += out.Value()
 	}
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	return balance
 }
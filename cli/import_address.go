@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) importAddress(address, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.ImportAddress(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching address: %s\n", address)
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) importPrivKey(wif, passphrase, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	address, err := wallets.ImportPrivateKey(wif)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Imported address: %s\n", address)
+}
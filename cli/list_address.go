@@ -7,7 +7,7 @@ import (
 	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
-func (cli *CLI) listAddresses(nodeID string) {
+func (cli *CLI) listAddresses(bech32 bool, nodeID string) {
 	wallets, err := wallet.NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
@@ -16,6 +16,15 @@ func (cli *CLI) listAddresses(nodeID string) {
 	addresses := wallets.GetAddresses()
 
 	for _, address := range addresses {
-		fmt.Println(address)
+		wallet := wallets.GetWallet(address)
+		if bech32 {
+			address = wallet.GetBech32Address()
+		}
+
+		if wallet.IsWatchOnly() {
+			fmt.Printf("%s (watch-only)\n", address)
+		} else {
+			fmt.Println(address)
+		}
 	}
 }
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/server"
+)
+
+func (cli *CLI) listPeers(nodeID string) {
+	book, err := server.NewAddrBook(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, ka := range book.List() {
+		fmt.Printf("--- Peer %s:\n", ka.Addr)
+		fmt.Printf("  Source:     %s\n", ka.Source)
+		fmt.Printf("  Services:   %s\n", ka.Services)
+		fmt.Printf("  Last seen:  %s\n", formatUnixTime(ka.LastSeen))
+		fmt.Printf("  Last heard: %s\n", formatUnixTime(ka.LastHeard))
+		fmt.Printf("  Tries:      %d since the last success, %d successes\n", ka.Attempts, ka.Successes)
+
+		if book.IsBanned(ka.Addr) {
+			fmt.Println("  Banned:     it or its host is banned")
+		}
+	}
+
+	for _, kh := range book.ListHosts() {
+		fmt.Printf("--- Host %s:\n", kh.Host)
+		fmt.Printf("  Score:      %d\n", kh.Score)
+
+		if kh.Banned() {
+			fmt.Printf("  Banned:     until %s, %s\n", formatUnixTime(kh.BannedUntil), kh.BanReason)
+		}
+	}
+}
+
+func formatUnixTime(timestamp int64) string {
+	if timestamp == 0 {
+		return "never"
+	}
+
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
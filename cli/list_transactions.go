@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+func (cli *CLI) listTransactions(address string, limit int, nodeID string) {
+	if address != "" && !utils.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := blockchain.NewBlockchain(nodeID)
+	defer bc.Close()
+
+	history := syncHistory(bc, nodeID)
+
+	bestHeight := bc.GetBestHeight()
+
+	for _, entry := range history.Transactions(address, limit) {
+		amount := entry.Amount()
+		if address != "" {
+			amount = entry.Amounts[address]
+		}
+
+		counterparties := strings.Join(entry.Counterparties, ", ")
+		if entry.Coinbase {
+			counterparties = "coinbase"
+		} else if counterparties == "" {
+			counterparties = "wallet addresses only"
+		}
+
+		fmt.Printf("--- Transaction %x:\n", entry.TxID)
+		fmt.Printf("  Height:         %d (%d confirmations)\n", entry.Height, entry.Confirmations(bestHeight))
+		fmt.Printf("  Time:           %s\n", time.Unix(entry.Timestamp, 0).UTC().Format(time.RFC3339))
+		fmt.Printf("  Amount:         %+d\n", amount)
+		fmt.Printf("  Counterparties: %s\n", counterparties)
+	}
+}
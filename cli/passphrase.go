@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+// readPassphrase returns the passphrase given on the command line or prompts for it on stdin
+func readPassphrase(passphrase, prompt string) string {
+	if passphrase != "" {
+		return passphrase
+	}
+
+	fmt.Printf("%s: ", prompt)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Panic(err)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
+
+// unlockWallets unlocks an encrypted wallet for the given time, unencrypted wallets are left untouched
+func unlockWallets(wallets *wallet.Wallets, passphrase string, timeout time.Duration) {
+	if !wallets.IsEncrypted() {
+		return
+	}
+
+	passphrase = readPassphrase(passphrase, "Wallet passphrase")
+
+	err := wallets.Unlock(passphrase, timeout)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) rescan(fromHeight int, nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	history, err := wallet.NewHistory(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := blockchain.NewBlockchain(nodeID)
+	defer bc.Close()
+
+	if fromHeight < 0 {
+		fmt.Printf("Resuming the rescan from block %d\n", history.Height()+1)
+	}
+
+	// Saving at every checkpoint lets an interrupted rescan resume from there
+	err = history.Rescan(bc, wallets, fromHeight, func(height, bestHeight int) {
+		history.SaveToFile(nodeID)
+		fmt.Printf("Rescanned up to block %d of %d\n", height, bestHeight)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println("Done!")
+}
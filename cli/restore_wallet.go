@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+func (cli *CLI) restoreWallet(mnemonic string, gapLimit int, passphrase, nodeID string) {
+	entropy, err := wallet.MnemonicToEntropy(mnemonic)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	_, err = wallets.InitHDChain(entropy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if utils.CheckDB(nodeID) {
+		bc := blockchain.NewBlockchain(nodeID)
+		defer bc.Close()
+
+		found, err := wallets.Rediscover(bc, gapLimit)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Found %d used address(es)\n", found)
+	} else {
+		fmt.Println("No blockchain found, used addresses will not be rediscovered.")
+	}
+
+	if len(wallets.GetAddresses()) == 0 {
+		wallets.CreateWallet()
+	}
+
+	wallets.SaveToFile(nodeID)
+
+	for _, address := range wallets.GetAddresses() {
+		fmt.Println(address)
+	}
+}
@@ -1,8 +1,12 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/chainstate"
@@ -11,35 +15,126 @@ import (
 	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
-	if !utils.ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+// recipientsFlag collects the values of a repeated -to flag
+type recipientsFlag []string
+
+func (rf *recipientsFlag) String() string {
+	return strings.Join(*rf, ",")
+}
+
+func (rf *recipientsFlag) Set(value string) error {
+	*rf = append(*rf, value)
+	return nil
+}
+
+func (cli *CLI) send(from []string, account bool, recipients []wallet.Recipient, feeRate int, strategy, passphrase string, unlockTimeout time.Duration, spendUnconfirmed bool, nodeID string, mineNow bool) {
+	for _, address := range from {
+		if !utils.ValidateAddress(address) {
+			log.Panicf("ERROR: Sender address %s is not valid", address)
+		}
 	}
-	if !utils.ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+
+	selector, err := wallet.NewCoinSelector(strategy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if account {
+		from = wallets.GetSpendableAddresses()
+		if len(from) == 0 {
+			fmt.Println("No spendable address found in the wallet file. Create or import a private key first.")
+			os.Exit(1)
+		}
 	}
 
 	bc := blockchain.NewBlockchain(nodeID)
 	UTXOSet := chainstate.NewUTXOSet(bc)
 	defer bc.Close()
 
-	wallets, err := wallet.NewWallets(nodeID)
+	// The history knows the transactions sent earlier that are still waiting for a block
+	history, err := wallet.NewHistory(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
 
-	tx := wallet.CreateTransaction(to, amount, UTXOSet)
+	history.Sync(bc, wallets)
+
+	unlockWallets(wallets, passphrase, unlockTimeout)
+	defer wallets.Lock()
+
+	var tx *transaction.Transaction
+	var selection *wallet.CoinSelection
+
+	if len(from) == 1 && !account {
+		wallet := wallets.GetWallet(from[0])
+		tx, selection = wallet.CreateTransaction(recipients, feeRate, selector, UTXOSet, history, spendUnconfirmed)
+	} else {
+		var changeAddress string
+
+		tx, selection, changeAddress = wallets.CreateTransaction(from, recipients, feeRate, selector, UTXOSet, history, spendUnconfirmed)
+		if changeAddress != "" {
+			wallets.SaveToFile(nodeID)
+			fmt.Printf("Change address: %s\n", changeAddress)
+		}
+	}
+
+	fmt.Println(selection)
 
 	if mineNow {
-		cbTx := transaction.NewCoinbaseTX(from, "")
+		cbTx := transaction.NewCoinbaseTX(from[0], "", bc.GetBestHeight()+1)
 		txs := []*transaction.Transaction{cbTx, tx}
 
 		newBlock := bc.MineBlock(txs)
 		UTXOSet.Update(newBlock)
 	} else {
-		cli.svc.SendTx(cli.svc.KnownNodes()[0], tx)
+		err = cli.svc.SendTx(tx)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		history.AddPending(tx)
 	}
 
+	history.SaveToFile(nodeID)
+
 	fmt.Println("Success!")
 }
+
+// buildRecipients merges the recipients given on -to flags with the ones read from a file
+// A -to value without an amount keeps the single-recipient form and receives the -amount flag
+func buildRecipients(to []string, amount int, file string) ([]wallet.Recipient, error) {
+	var recipients []wallet.Recipient
+
+	for _, value := range to {
+		if !strings.Contains(value, ":") {
+			value = fmt.Sprintf("%s:%d", value, amount)
+		}
+
+		recipient, err := wallet.ParseRecipient(value)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, *recipient)
+	}
+
+	if file != "" {
+		fromFile, err := wallet.LoadRecipients(file)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, fromFile...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("ERROR: No recipients given")
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+// signPSBT only needs the wallet file, so it can run on a machine without the blockchain
+func (cli *CLI) signPSBT(in, out, passphrase, nodeID string) {
+	pst, err := wallet.LoadPartiallySignedTransaction(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(pst)
+
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	unlockWallets(wallets, passphrase, 0)
+	defer wallets.Lock()
+
+	signed, err := wallets.Sign(pst)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	pst.SaveToFile(out)
+
+	fmt.Printf("Signed %d input(s), written to %s\n", signed, out)
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
 )
 
 func (cli *CLI) startNode(nodeID, minerAddress string) {
@@ -17,5 +18,7 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 		}
 	}
 
+	// The wallet history follows the blocks the node connects instead of catching up on the next wallet command
+	cli.svc.Watch(wallet.NewHistoryUpdater(nodeID))
 	cli.svc.Start(minerAddress)
 }
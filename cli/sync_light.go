@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/lugassawan/learning-golang-blockchain/wallet"
+)
+
+// syncLight syncs the headers of the chain and the proven transactions of every wallet address from a full node,
+// then prints their balances. It needs neither the blockchain nor a running node
+func (cli *CLI) syncLight(nodeID string) {
+	wallets, err := wallet.NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain, err := wallet.NewLightChain(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	addresses := wallets.GetAddresses()
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		wallet := wallets.GetWallet(address)
+		chain.Watch(wallet.GetPubKeyHash())
+	}
+
+	err = cli.svc.SyncLight(chain)
+
+	// Whatever was synced and proven is kept, the next sync goes on from there
+	chain.SaveToFile(nodeID)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Synced %d headers, %d wallet transactions proven\n", chain.Height()+1, len(chain.Transactions))
+
+	total := 0
+
+	for _, address := range addresses {
+		wallet := wallets.GetWallet(address)
+		balance := chain.Balance(wallet.GetPubKeyHash())
+		total += balance
+
+		fmt.Printf("Balance of '%s': %d\n", address, balance)
+	}
+
+	fmt.Printf("Total balance: %d\n", total)
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	DefaultMaxOutbound = 8
+	DefaultMaxInbound  = 32
+	DefaultBanDuration = 24 * time.Hour
+)
+
+var (
+	errNoNodeID         = errors.New("ERROR: Node ID is not set, use -node_id, the node_id setting or the NODE_ID env. var")
+	errConnectionLimits = errors.New("ERROR: max_outbound must be at least 1 and max_inbound can't be negative")
+	errBanDuration      = errors.New("ERROR: ban_duration must be positive")
+)
+
+// Config holds the settings of a node
+// They are read from a JSON file, then overridden by the NODE_ID, NETWORK and CHAIN_PARAMS env. vars and the global flags
+type Config struct {
+	NodeID          string   `json:"node_id"`
+	DataDir         string   `json:"data_dir"`
+	Listen          string   `json:"listen"`
+	ExternalAddress string   `json:"external_address"`
+	SeedNodes       []string `json:"seed_nodes"`
+	MiningAddress   string   `json:"mining_address"`
+	LogLevel        string   `json:"log_level"`
+	Network         string   `json:"network"`
+	ChainParams     string   `json:"chain_params"`
+	MaxOutbound     int      `json:"max_outbound"`
+	MaxInbound      int      `json:"max_inbound"`
+	BanDuration     Duration `json:"ban_duration"`
+}
+
+// Duration is a time.Duration written as "24h" or "90m" in the configuration file
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+
+	return err
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Load builds the configuration from the global flags leading the arguments and returns the arguments left
+// The network, the data directory and the log level of the configuration are made active
+func Load(args []string) (*Config, []string, error) {
+	var seedNodes string
+
+	flags := flag.NewFlagSet("global", flag.ExitOnError)
+	configFile := flags.String("config", "", "JSON file with the node settings")
+	nodeID := flags.String("node_id", "", "ID of the node, suffixes its data files and is its port by default")
+	dataDir := flags.String("data_dir", "", "Directory of the blockchain, wallet and history files")
+	listen := flags.String("listen", "", "Address to listen on for peers, localhost:NODE_ID by default")
+	externalAddress := flags.String("external_address", "", "Address peers reach the node at, the listen address by default")
+	flags.StringVar(&seedNodes, "seed_nodes", "", "Comma-separated list of peers to connect to first, the seed nodes of the network by default")
+	miningAddress := flags.String("mining_address", "", "Address to send mining rewards to")
+	logLevel := flags.String("log_level", "", "Lowest level of the node log: debug, info, warn or error")
+	network := flags.String("network", "", "Network to run on: mainnet, testnet or regtest")
+	chainParams := flags.String("chain_params", "", "JSON file with the parameters of a private network, takes precedence over -network")
+	maxOutbound := flags.Int("max_outbound", DefaultMaxOutbound, "Number of peers the node keeps connections open to")
+	maxInbound := flags.Int("max_inbound", DefaultMaxInbound, "Number of connections from peers the node accepts")
+	banDuration := flags.Duration("ban_duration", DefaultBanDuration, "How long a misbehaving peer is banned for")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &Config{
+		DataDir:     utils.DefaultDataDir,
+		LogLevel:    "info",
+		MaxOutbound: DefaultMaxOutbound,
+		MaxInbound:  DefaultMaxInbound,
+		BanDuration: Duration{DefaultBanDuration},
+	}
+
+	if *configFile != "" {
+		err = cfg.loadFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cfg.loadEnv()
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "node_id":
+			cfg.NodeID = *nodeID
+		case "data_dir":
+			cfg.DataDir = *dataDir
+		case "listen":
+			cfg.Listen = *listen
+		case "external_address":
+			cfg.ExternalAddress = *externalAddress
+		case "seed_nodes":
+			cfg.SeedNodes = strings.Split(seedNodes, ",")
+		case "mining_address":
+			cfg.MiningAddress = *miningAddress
+		case "log_level":
+			cfg.LogLevel = *logLevel
+		case "network":
+			cfg.Network = *network
+		case "chain_params":
+			cfg.ChainParams = *chainParams
+		case "max_outbound":
+			cfg.MaxOutbound = *maxOutbound
+		case "max_inbound":
+			cfg.MaxInbound = *maxInbound
+		case "ban_duration":
+			cfg.BanDuration = Duration{*banDuration}
+		}
+	})
+
+	err = cfg.apply()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(fileContent, cfg)
+	if err != nil {
+		return fmt.Errorf("ERROR: %s is not a configuration file: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv keeps the env. vars the node was configured with before the configuration file existed
+func (cfg *Config) loadEnv() {
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		cfg.NodeID = nodeID
+	}
+
+	if network := os.Getenv("NETWORK"); network != "" {
+		cfg.Network = network
+	}
+
+	if chainParams := os.Getenv("CHAIN_PARAMS"); chainParams != "" {
+		cfg.ChainParams = chainParams
+	}
+}
+
+// apply activates the settings and fills in the addresses left empty
+func (cfg *Config) apply() error {
+	if cfg.NodeID == "" {
+		return errNoNodeID
+	}
+
+	if cfg.MaxOutbound < 1 || cfg.MaxInbound < 0 {
+		return errConnectionLimits
+	}
+
+	if cfg.BanDuration.Duration <= 0 {
+		return errBanDuration
+	}
+
+	err := cfg.selectNetwork()
+	if err != nil {
+		return err
+	}
+
+	level, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	logger.SetLevel(level)
+	utils.SetDataDir(cfg.DataDir)
+
+	if cfg.Listen == "" {
+		cfg.Listen = fmt.Sprintf("localhost:%s", cfg.NodeID)
+	}
+
+	if cfg.ExternalAddress == "" {
+		cfg.ExternalAddress = cfg.Listen
+	}
+
+	if len(cfg.SeedNodes) == 0 {
+		cfg.SeedNodes = chaincfg.ActiveParams().SeedNodes()
+	}
+
+	return nil
+}
+
+// selectNetwork activates the network of the chain parameters file, or the named one
+func (cfg *Config) selectNetwork() error {
+	if cfg.ChainParams == "" {
+		return chaincfg.SelectNetwork(cfg.Network)
+	}
+
+	params, err := chaincfg.LoadParams(cfg.ChainParams)
+	if err != nil {
+		return err
+	}
+
+	chaincfg.UseParams(params)
+
+	return nil
+}
@@ -3,7 +3,8 @@ module github.com/lugassawan/learning-golang-blockchain
 go 1.22.0
 
 require (
-	go.etcd.io/bbolt v1.3.8 // indirect
-	golang.org/x/crypto v0.19.0 // indirect
-	golang.org/x/sys v0.17.0 // indirect
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.19.0
 )
+
+require golang.org/x/sys v0.17.0 // indirect
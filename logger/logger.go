@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level orders log messages by importance, only the ones at or above the configured level are written
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+var level = LevelInfo
+
+// ParseLevel returns the level with the name, debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	parsed, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("ERROR: Unknown log level %q, expected debug, info, warn or error", name)
+	}
+
+	return parsed, nil
+}
+
+// SetLevel sets the lowest level written
+func SetLevel(l Level) {
+	level = l
+}
+
+// Debugf writes a message only useful to follow the node closely, such as every command received
+func Debugf(format string, v ...interface{}) {
+	logf(LevelDebug, "DEBUG", format, v...)
+}
+
+// Infof writes a message about the normal operation of the node
+func Infof(format string, v ...interface{}) {
+	logf(LevelInfo, "INFO", format, v...)
+}
+
+// Warnf writes a message about something unexpected the node recovers from
+func Warnf(format string, v ...interface{}) {
+	logf(LevelWarn, "WARN", format, v...)
+}
+
+// Errorf writes a message about a failure
+func Errorf(format string, v ...interface{}) {
+	logf(LevelError, "ERROR", format, v...)
+}
+
+func logf(l Level, prefix, format string, v ...interface{}) {
+	if l < level {
+		return
+	}
+
+	log.Printf("[%s] %s", prefix, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,407 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	addrBookFile     = "peers_%s.dat"
+	addrBookFileMode = 0644
+
+	// SourceSeed and SourceManual mark the addresses that came from the configuration and the add_peer command
+	// The other ones hold the address of the peer that told about them
+	SourceSeed   = "seed"
+	SourceManual = "manual"
+
+	// retryInterval is how long a failed address is left alone, doubled after every further failure up to maxRetryShift times
+	retryInterval = 30 * time.Second
+	maxRetryShift = 6
+	// maxFailures failed dials in a row make the node forget an address learnt from a peer
+	maxFailures = 5
+)
+
+// AddrBook is the list of the nodes the node knows about, saved between runs
+// The caller serializes the access to it
+type AddrBook struct {
+	Addresses map[string]*KnownAddress
+	// Hosts holds the misbehaviour of the hosts peers connected from, by the key banKey gives
+	Hosts map[string]*KnownHost
+	// modTime is the modification time of the file when the book was last loaded or saved
+	modTime time.Time
+}
+
+// KnownAddress is what the node learnt about a node
+// LastSeen is the last handshake completed with it, LastHeard the last time a peer told it was up
+// Attempts counts the dials since the last successful one, Successes the completed handshakes
+type KnownAddress struct {
+	Addr        string
+	Source      string
+	Services    ServiceFlag
+	LastSeen    int64
+	LastHeard   int64
+	LastAttempt int64
+	Attempts    int
+	Successes   int
+}
+
+// KnownHost is the misbehaviour of the peers connecting from an IP address, or of a node of a loopback or private network
+// Score adds up the misbehaviour, a ban lasts until BannedUntil
+// A peer picks the address it claims to listen on but not the one it connects from, so scores and bans go to the latter
+type KnownHost struct {
+	Host        string
+	Score       int
+	BannedUntil int64
+	BanReason   string
+}
+
+// NewAddrBook creates AddrBook and fills it from a file if it exists
+func NewAddrBook(nodeID string) (*AddrBook, error) {
+	book := AddrBook{Addresses: make(map[string]*KnownAddress)}
+
+	err := book.LoadFromFile(nodeID)
+
+	return &book, err
+}
+
+// Banned tells whether the host is banned at the moment
+func (kh *KnownHost) Banned() bool {
+	return kh.BannedUntil > time.Now().Unix()
+}
+
+// hostOf returns the host of an address, a host is returned as it is
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// banKey returns what the misbehaviour of the address is recorded under, the host of the address
+// Nodes of a loopback or private network often share their IP address, such an address is its own key with localhost
+// standing for 127.0.0.1
+func banKey(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !isLocalHost(host) {
+		return hostOf(addr)
+	}
+
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// isLocalHost tells whether the host is on a loopback or private network
+func isLocalHost(host string) bool {
+	ip := net.ParseIP(host)
+
+	return host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+}
+
+// LastKnown returns the last time the node is known to have been up
+func (ka *KnownAddress) LastKnown() int64 {
+	return max(ka.LastSeen, ka.LastHeard)
+}
+
+// retryAt returns when the address may be dialed again
+func (ka *KnownAddress) retryAt() time.Time {
+	if ka.Attempts == 0 {
+		return time.Time{}
+	}
+
+	delay := retryInterval << min(ka.Attempts-1, maxRetryShift)
+
+	return time.Unix(ka.LastAttempt, 0).Add(delay)
+}
+
+// Add adds an address the node did not know about and returns whether it did so
+func (ab *AddrBook) Add(addr, source string) bool {
+	if _, ok := ab.Addresses[addr]; ok {
+		return false
+	}
+
+	ab.Addresses[addr] = &KnownAddress{Addr: addr, Source: source}
+
+	return true
+}
+
+// Lookup returns what the node knows about the address, nil if nothing
+func (ab *AddrBook) Lookup(addr string) *KnownAddress {
+	return ab.Addresses[addr]
+}
+
+// Remove forgets the address
+func (ab *AddrBook) Remove(addr string) {
+	delete(ab.Addresses, addr)
+}
+
+// Attempt records a dial of the address
+func (ab *AddrBook) Attempt(addr string) {
+	if ka := ab.Addresses[addr]; ka != nil {
+		ka.Attempts++
+		ka.LastAttempt = time.Now().Unix()
+	}
+}
+
+// Heard records that a peer told the address was up at the time given, and returns whether the address is new
+func (ab *AddrBook) Heard(addr, source string, services ServiceFlag, timestamp int64) bool {
+	isNew := ab.Add(addr, source)
+
+	ka := ab.Addresses[addr]
+	ka.LastHeard = max(ka.LastHeard, timestamp)
+
+	if services != 0 {
+		ka.Services = services
+	}
+
+	return isNew
+}
+
+// Connected records a handshake completed with the address
+func (ab *AddrBook) Connected(addr string, services ServiceFlag) {
+	if ka := ab.Addresses[addr]; ka != nil {
+		ka.Attempts = 0
+		ka.Successes++
+		ka.LastSeen = time.Now().Unix()
+		ka.Services = services
+	}
+}
+
+// host returns what the node knows about the host, recording it first if it knew nothing
+func (ab *AddrBook) host(host string) *KnownHost {
+	if ab.Hosts == nil {
+		ab.Hosts = make(map[string]*KnownHost)
+	}
+
+	kh := ab.Hosts[host]
+	if kh == nil {
+		kh = &KnownHost{Host: host}
+		ab.Hosts[host] = kh
+	}
+
+	return kh
+}
+
+// Misbehave adds to the misbehaviour score of the host, or of the address on a local network, and returns the new score
+func (ab *AddrBook) Misbehave(host string, score int) int {
+	kh := ab.host(banKey(host))
+	kh.Score += score
+
+	return kh.Score
+}
+
+// Ban keeps the node away from the host, or from the address on a local network, until the time given
+// A time in the past lifts the ban, which clears the misbehaviour score as well
+func (ab *AddrBook) Ban(host string, until time.Time, reason string) {
+	kh := ab.host(banKey(host))
+	kh.BannedUntil = until.Unix()
+	kh.BanReason = reason
+
+	if !kh.Banned() {
+		kh.BannedUntil = 0
+		kh.BanReason = ""
+		kh.Score = 0
+	}
+}
+
+// IsBanned tells whether the host, or the host of the address, is banned at the moment
+// On a local network the address itself has to be banned
+func (ab *AddrBook) IsBanned(addr string) bool {
+	kh := ab.Hosts[banKey(addr)]
+
+	return kh != nil && kh.Banned()
+}
+
+// ListHosts returns the hosts that misbehaved
+func (ab *AddrBook) ListHosts() []*KnownHost {
+	var list []*KnownHost
+
+	for _, kh := range ab.Hosts {
+		list = append(list, kh)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Host < list[j].Host
+	})
+
+	return list
+}
+
+// List returns every known address, the ones known to be up most recently first
+func (ab *AddrBook) List() []*KnownAddress {
+	var list []*KnownAddress
+
+	for _, ka := range ab.Addresses {
+		list = append(list, ka)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].LastKnown() != list[j].LastKnown() {
+			return list[i].LastKnown() > list[j].LastKnown()
+		}
+
+		return list[i].Addr < list[j].Addr
+	})
+
+	return list
+}
+
+// Candidates returns up to n addresses worth dialing, skipping the excluded ones, banned ones and the ones failing lately
+// Addresses known to be up lately come first, the seed nodes are only dialed when the other addresses are not enough
+func (ab *AddrBook) Candidates(n int, exclude map[string]bool) []string {
+	var candidates, seeds []string
+
+	now := time.Now()
+
+	for _, ka := range ab.List() {
+		if exclude[ka.Addr] || ab.IsBanned(ka.Addr) || now.Before(ka.retryAt()) {
+			continue
+		}
+
+		if ka.Source == SourceSeed {
+			seeds = append(seeds, ka.Addr)
+		} else {
+			candidates = append(candidates, ka.Addr)
+		}
+	}
+
+	candidates = append(candidates, seeds...)
+
+	return candidates[:min(n, len(candidates))]
+}
+
+// Fresh returns up to n addresses of full nodes known to be up within maxAge, skipping the excluded ones and banned ones
+func (ab *AddrBook) Fresh(n int, maxAge time.Duration, exclude map[string]bool) []*KnownAddress {
+	var fresh []*KnownAddress
+
+	oldest := time.Now().Add(-maxAge).Unix()
+
+	for _, ka := range ab.List() {
+		if len(fresh) == n {
+			break
+		}
+
+		if exclude[ka.Addr] || ab.IsBanned(ka.Addr) || !ka.Services.Has(SFNodeNetwork) || ka.LastKnown() < oldest {
+			continue
+		}
+
+		fresh = append(fresh, ka)
+	}
+
+	return fresh
+}
+
+// Prune forgets the addresses learnt from peers that could not be reached maxFailures times in a row
+// or that nobody heard of for maxAge. Lifts the bans that are over too
+func (ab *AddrBook) Prune(maxAge time.Duration) {
+	oldest := time.Now().Add(-maxAge).Unix()
+
+	for addr, ka := range ab.Addresses {
+		learnt := ka.Source != SourceSeed && ka.Source != SourceManual
+		if learnt && (ka.Attempts >= maxFailures || ka.LastKnown() < oldest) {
+			delete(ab.Addresses, addr)
+		}
+	}
+
+	for host, kh := range ab.Hosts {
+		if kh.BannedUntil != 0 && !kh.Banned() {
+			ab.Ban(host, time.Time{}, "")
+		}
+	}
+}
+
+// Merge takes the addresses and bans of another book, such as the file edited by add_peer and ban_peer
+func (ab *AddrBook) Merge(other *AddrBook) {
+	for addr, ka := range other.Addresses {
+		if ab.Add(addr, ka.Source) {
+			*ab.Addresses[addr] = *ka
+		}
+	}
+
+	for host, kh := range other.Hosts {
+		if ours := ab.Hosts[host]; ours == nil {
+			*ab.host(host) = *kh
+		} else if kh.BannedUntil != ours.BannedUntil {
+			ab.Ban(host, time.Unix(kh.BannedUntil, 0), kh.BanReason)
+		}
+	}
+}
+
+// FileChanged tells whether the file was written by someone else since the book was loaded or saved
+func (ab *AddrBook) FileChanged(nodeID string) bool {
+	info, err := os.Stat(utils.DataPath(fmt.Sprintf(addrBookFile, nodeID)))
+
+	return err == nil && !info.ModTime().Equal(ab.modTime)
+}
+
+// LoadFromFile loads the addresses from the file
+func (ab *AddrBook) LoadFromFile(nodeID string) error {
+	bookFile := utils.DataPath(fmt.Sprintf(addrBookFile, nodeID))
+
+	info, err := os.Stat(bookFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fileContent, err := os.ReadFile(bookFile)
+	if err != nil {
+		return err
+	}
+
+	var book AddrBook
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&book)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if book.Addresses != nil {
+		ab.Addresses = book.Addresses
+	}
+
+	ab.Hosts = book.Hosts
+
+	ab.modTime = info.ModTime()
+
+	return nil
+}
+
+// SaveToFile saves the addresses to a file
+func (ab *AddrBook) SaveToFile(nodeID string) {
+	var content bytes.Buffer
+	bookFile := utils.DataPath(fmt.Sprintf(addrBookFile, nodeID))
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(ab)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(bookFile, content.Bytes(), addrBookFileMode)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	info, err := os.Stat(bookFile)
+	if err == nil {
+		ab.modTime = info.ModTime()
+	}
+}
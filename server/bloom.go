@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// bloomFiltersVersion is the protocol version that introduced bloom filters and filtered blocks
+	bloomFiltersVersion = 6
+	// maxFilterAddSize is the largest datum a filteradd may add, like BIP 37
+	maxFilterAddSize = 520
+)
+
+// handleFilterLoad sets the filter of the peer, a peer loading an empty filter or one larger than allowed misbehaves
+func (s *Server) handleFilterLoad(p *Peer, payload filterload) {
+	if payload.Filter == nil || !payload.Filter.IsWithinLimits() {
+		s.misbehave(p, scoreMalformedMessage, "loaded a bloom filter out of the size limits")
+		return
+	}
+
+	p.filter = payload.Filter
+}
+
+// handleFilterAdd adds the data to the filter of the peer, a peer without a filter or adding too much misbehaves
+func (s *Server) handleFilterAdd(p *Peer, payload filteradd) {
+	if p.filter == nil || len(payload.Data) > maxFilterAddSize {
+		s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("added %d bytes to a bloom filter", len(payload.Data)))
+		return
+	}
+
+	p.filter.Add(payload.Data)
+}
+
+// handleFilterClear removes the filter of the peer, every transaction is relayed to it again
+func (s *Server) handleFilterClear(p *Peer) {
+	p.filter = nil
+}
+
+// matchesFilter tells whether the transaction of the mempool with the ID can be relayed to the peer. The caller holds s.mu
+func (s *Server) matchesFilter(p *Peer, txID []byte) bool {
+	if p.filter == nil {
+		return true
+	}
+
+	tx := s.mempool[hex.EncodeToString(txID)]
+
+	return tx != nil && tx.MatchesFilter(p.filter)
+}
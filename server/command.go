@@ -2,7 +2,7 @@ package server
 
 import "fmt"
 
-func (s *Server) commandToBytes(command string) []byte {
+func commandToBytes(command string) []byte {
 	var bytes [commandLength]byte
 
 	for i, c := range command {
@@ -12,7 +12,7 @@ func (s *Server) commandToBytes(command string) []byte {
 	return bytes[:]
 }
 
-func (s *Server) bytesToCommand(bytes []byte) string {
+func bytesToCommand(bytes []byte) string {
 	var command []byte
 
 	for _, b := range bytes {
@@ -23,13 +23,3 @@ func (s *Server) bytesToCommand(bytes []byte) string {
 
 	return fmt.Sprintf("%s", command)
 }
-
-func (s *Server) extractCommand(request []byte) []byte {
-	return request[:commandLength]
-}
-
-func (s *Server) requestBlocks() {
-	for _, node := range s.knownNodes {
-		s.sendGetBlocks(node)
-	}
-}
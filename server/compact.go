@@ -0,0 +1,262 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+)
+
+const (
+	// compactBlocksVersion is the protocol version that introduced compact blocks
+	compactBlocksVersion = 5
+	// maxHighBandwidthPeers is how many peers the node asks to push new blocks as compact blocks right away
+	maxHighBandwidthPeers = 3
+	// shortIDLength is how many bytes of a short transaction ID are sent
+	shortIDLength = 6
+)
+
+// partialBlock is a compact block waiting for the transactions the mempool didn't have, from is the peer they are requested from
+type partialBlock struct {
+	header       *blockchain.BlockHeader
+	transactions []*transaction.Transaction
+	from         *Peer
+}
+
+// shortID returns the short ID of a transaction in a compact block
+// The hash of the block and the nonce of the compact block are mixed in, so nobody can make two transactions collide in every block
+func shortID(blockHash []byte, nonce uint64, txID []byte) uint64 {
+	var data []byte
+	data = append(data, blockHash...)
+	data = binary.LittleEndian.AppendUint64(data, nonce)
+	data = append(data, txID...)
+
+	hash := sha256.Sum256(data)
+
+	var id [8]byte
+	copy(id[:], hash[:shortIDLength])
+
+	return binary.LittleEndian.Uint64(id[:])
+}
+
+// newCompactBlock returns the compact block of a block, the coinbase transaction is sent in full since no mempool has it
+func newCompactBlock(b *blockchain.Block) cmpctblock {
+	compact := cmpctblock{Header: b.Header(), Nonce: randomNonce()}
+
+	for i, tx := range b.Transactions() {
+		if tx.IsCoinbase() {
+			compact.Prefilled = append(compact.Prefilled, prefilledTx{i, tx.Serialize()})
+		} else {
+			compact.ShortIDs = append(compact.ShortIDs, shortID(b.Hash(), compact.Nonce, tx.ID()))
+		}
+	}
+
+	return compact
+}
+
+// highBandwidthPeers returns how many peers the node asked to push compact blocks. The caller holds s.mu
+func (s *Server) highBandwidthPeers() int {
+	count := 0
+
+	for p := range s.peers {
+		if p.pushesCompact {
+			count++
+		}
+	}
+
+	return count
+}
+
+// requestCompactBlocks tells a peer speaking compact blocks how the node wants to hear of new blocks. The caller holds s.mu
+// A few outbound peers are asked to push them right away, the others announce them with inv as usual
+func (s *Server) requestCompactBlocks(p *Peer) {
+	if p.version < compactBlocksVersion {
+		return
+	}
+
+	p.pushesCompact = !p.inbound && s.highBandwidthPeers() < maxHighBandwidthPeers
+	s.sendSendCmpct(p, p.pushesCompact)
+}
+
+// handleSendCmpct records whether the peer wants new blocks pushed as compact blocks
+func (s *Server) handleSendCmpct(p *Peer, payload sendcmpct) {
+	p.wantsCompact = payload.Announce
+}
+
+// handleCmpctBlock rebuilds a block from its compact block and the mempool, the missing transactions are requested from the peer
+// A compact block whose header doesn't follow a known block makes the node ask for the blocks leading to it
+// A prefilled transaction that can't be decoded is returned as an error
+func (s *Server) handleCmpctBlock(p *Peer, payload cmpctblock) error {
+	header := payload.Header
+	if header == nil {
+		s.misbehave(p, scoreMalformedMessage, "sent a compact block without a header")
+		return nil
+	}
+
+	p.knownInventory.Add(header.Hash())
+
+	if _, err := s.bc.GetBlock(header.Hash()); err == nil {
+		return nil
+	}
+
+	err := s.acceptHeader(header)
+	if err == errUnknownHeaderParent {
+		s.requestBlocks(p)
+		return nil
+	}
+
+	if err != nil {
+		s.misbehave(p, scoreInvalidBlock, err.Error())
+		return nil
+	}
+
+	p.bestHeight = max(p.bestHeight, header.Height())
+
+	count := len(payload.ShortIDs) + len(payload.Prefilled)
+	txs := make([]*transaction.Transaction, count)
+
+	for _, prefilled := range payload.Prefilled {
+		if prefilled.Index < 0 || prefilled.Index >= count || txs[prefilled.Index] != nil {
+			s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("sent compact block %x with a bad prefilled index", header.Hash()))
+			return nil
+		}
+
+		tx, err := transaction.DecodeTransaction(prefilled.Transaction)
+		if err != nil {
+			return err
+		}
+
+		txs[prefilled.Index] = &tx
+	}
+
+	// Short IDs two mempool transactions share can't be told apart, the block is fetched in full then
+	mempool := make(map[uint64]*transaction.Transaction)
+	collisions := make(map[uint64]bool)
+
+	for _, tx := range s.mempool {
+		id := shortID(header.Hash(), payload.Nonce, tx.ID())
+		if mempool[id] != nil {
+			collisions[id] = true
+		}
+
+		mempool[id] = tx
+	}
+
+	var missing []int
+	next := 0
+
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+
+		id := payload.ShortIDs[next]
+		next++
+
+		if collisions[id] {
+			s.requestFullBlock(p, header.Hash())
+			return nil
+		}
+
+		if txs[i] = mempool[id]; txs[i] == nil {
+			missing = append(missing, i)
+		}
+	}
+
+	logger.Debugf("Compact block %x from %s has %d transactions, %d missing from the mempool", header.Hash(), p.addr, count, len(missing))
+
+	if len(missing) == 0 {
+		s.completeCompactBlock(p, header, txs)
+		return nil
+	}
+
+	key := hex.EncodeToString(header.Hash())
+	s.partialBlocks[key] = &partialBlock{header, txs, p}
+	s.sync.inFlight[key] = &blockRequest{p, time.Now()}
+	s.sendGetBlockTxn(p, header.Hash(), missing)
+
+	return nil
+}
+
+// handleGetBlockTxn answers with the transactions of a block the peer is missing to rebuild it
+func (s *Server) handleGetBlockTxn(p *Peer, payload getblocktxn) {
+	block, err := s.bc.GetBlock(payload.BlockHash)
+	if err != nil {
+		return
+	}
+
+	var txs [][]byte
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.Transactions()) {
+			s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("asked for transaction %d of block %x", i, block.Hash()))
+			return
+		}
+
+		txs = append(txs, block.Transactions()[i].Serialize())
+	}
+
+	s.sendBlockTxn(p, block.Hash(), txs)
+}
+
+// handleBlockTxn fills a partial block with the missing transactions the peer sent
+// A transaction that can't be decoded is returned as an error
+func (s *Server) handleBlockTxn(p *Peer, payload blocktxn) error {
+	key := hex.EncodeToString(payload.BlockHash)
+
+	partial := s.partialBlocks[key]
+	if partial == nil || partial.from != p {
+		return nil
+	}
+
+	delete(s.partialBlocks, key)
+
+	next := 0
+	for i, tx := range partial.transactions {
+		if tx != nil {
+			continue
+		}
+
+		if next == len(payload.Transactions) {
+			s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("sent too few transactions of block %x", payload.BlockHash))
+			return nil
+		}
+
+		missing, err := transaction.DecodeTransaction(payload.Transactions[next])
+		if err != nil {
+			return err
+		}
+
+		partial.transactions[i] = &missing
+		next++
+	}
+
+	s.completeCompactBlock(p, partial.header, partial.transactions)
+
+	return nil
+}
+
+// completeCompactBlock hands a rebuilt block over to the sync
+// A block that doesn't match its header was rebuilt from the wrong transactions, it is fetched in full then. The caller holds s.mu
+func (s *Server) completeCompactBlock(p *Peer, header *blockchain.BlockHeader, txs []*transaction.Transaction) {
+	block := blockchain.NewBlockFromHeader(header, txs)
+
+	if !blockchain.NewProofOfWork(block).Validate() {
+		logger.Debugf("Block %x rebuilt from a compact block doesn't match its header", header.Hash())
+		s.requestFullBlock(p, header.Hash())
+		return
+	}
+
+	logger.Infof("Rebuilt block %x from a compact block", header.Hash())
+	s.receiveBlock(p, block)
+}
+
+// requestFullBlock asks the peer for the block a compact block could not be rebuilt into. The caller holds s.mu
+func (s *Server) requestFullBlock(p *Peer, hash []byte) {
+	s.sync.inFlight[hex.EncodeToString(hash)] = &blockRequest{p, time.Now()}
+	s.sendGetData(p, "block", hash)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+)
+
+const (
+	// addrVersion is the protocol version that introduced getaddr and timestamped addr messages
+	addrVersion = 3
+	// maxAddrPerMessage is the most addresses an addr message may carry
+	maxAddrPerMessage = 1000
+	// addrMaxAge is how long an address nobody heard of is still gossiped about
+	addrMaxAge = 3 * 24 * time.Hour
+	// Addresses announced in small addr messages and up within addrFreshness are relayed to addrRelayFanout peers
+	maxAddrAnnouncement = 10
+	addrFreshness       = 10 * time.Minute
+	addrRelayFanout     = 2
+	// A peer may send addrRateLimit addresses per second on average, up to addrBurst at once
+	addrRateLimit = 0.1
+	addrBurst     = maxAddrPerMessage
+)
+
+// takeAddrToken tells whether the peer may send one more address, refilling its tokens at addrRateLimit per second
+func (p *Peer) takeAddrToken() bool {
+	now := time.Now()
+	p.addrTokens = min(p.addrTokens+now.Sub(p.addrTokensAt).Seconds()*addrRateLimit, addrBurst)
+	p.addrTokensAt = now
+
+	if p.addrTokens < 1 {
+		return false
+	}
+
+	p.addrTokens--
+
+	return true
+}
+
+// sanitizeTimestamp keeps a peer from making an address look fresher than it can be
+// A timestamp in the future or missing makes the address five days old, like bitcoind does
+func sanitizeTimestamp(timestamp int64) int64 {
+	now := time.Now()
+
+	if timestamp <= 0 || timestamp > now.Add(addrFreshness).Unix() {
+		return now.Add(-5 * 24 * time.Hour).Unix()
+	}
+
+	return timestamp
+}
+
+// advertise tells a peer the address of the node and asks an outbound one for the addresses it knows. The caller holds s.mu
+func (s *Server) advertise(p *Peer) {
+	if p.version < addrVersion {
+		return
+	}
+
+	s.sendAddr(p, []netAddress{{s.nodeAddress, s.services(), time.Now().Unix()}})
+
+	if !p.inbound {
+		// The answer to getaddr is not held to the rate limit
+		p.addrTokens += maxAddrPerMessage
+		s.sendGetAddr(p)
+	}
+}
+
+// relayAddrs sends fresh addresses to a few random peers other than the one they came from, each address once per peer
+// The caller holds s.mu
+func (s *Server) relayAddrs(from *Peer, addrs []netAddress) {
+	if len(addrs) == 0 {
+		return
+	}
+
+	var peers []*Peer
+	for p := range s.peers {
+		if p != from && p.state == peerEstablished && p.version >= addrVersion {
+			peers = append(peers, p)
+		}
+	}
+
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	for _, p := range peers[:min(addrRelayFanout, len(peers))] {
+		var unknown []netAddress
+
+		for _, a := range addrs {
+			if !p.knownAddrs[a.Addr] {
+				p.knownAddrs[a.Addr] = true
+				unknown = append(unknown, a)
+			}
+		}
+
+		if len(unknown) > 0 {
+			logger.Debugf("Relaying %d addresses to %s", len(unknown), p.addr)
+			s.sendAddr(p, unknown)
+		}
+	}
+}
@@ -5,260 +5,621 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"io"
-	"log"
-	"net"
+	"math/rand"
+	"slices"
+	"time"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
 )
 
-func (s *Server) handleAddr(request []byte) {
-	var buff bytes.Buffer
-	var payload addr
+// handleAddr adds the addresses to the address book, the node dials them when it needs more peers
+// Addresses beyond the rate limit of the peer are dropped, fresh ones from a small announcement are relayed
+func (s *Server) handleAddr(p *Peer, payload addr) {
+	if len(payload.AddrList) > maxAddrPerMessage {
+		s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("sent %d addresses at once", len(payload.AddrList)))
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	var relay []netAddress
+	dropped, learnt := 0, 0
+	fresh := time.Now().Add(-addrFreshness).Unix()
+
+	for _, a := range payload.AddrList {
+		p.knownAddrs[a.Addr] = true
+
+		if !p.takeAddrToken() {
+			dropped++
+			continue
+		}
+
+		a.Timestamp = sanitizeTimestamp(a.Timestamp)
+		if a.Addr == s.nodeAddress || a.Timestamp < time.Now().Add(-addrMaxAge).Unix() {
+			continue
+		}
+
+		if s.addrBook.Heard(a.Addr, p.addr, a.Services, a.Timestamp) {
+			learnt++
+		}
+
+		if a.Timestamp >= fresh && len(payload.AddrList) <= maxAddrAnnouncement {
+			relay = append(relay, a)
+		}
 	}
 
-	s.knownNodes = append(s.knownNodes, payload.addrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(s.knownNodes))
-	s.requestBlocks()
-}
+	if dropped > 0 {
+		logger.Debugf("Dropped %d addresses from %s over the rate limit", dropped, p.addr)
+	}
+
+	if learnt > 0 {
+		logger.Infof("Learnt %d addresses from %s, there are %d known nodes now!", learnt, p.addr, len(s.addrBook.Addresses))
 
-func (s *Server) handleBlock(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload block
+		if s.peerCount(false) < s.maxOutbound {
+			s.requestRefresh()
+		}
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	s.relayAddrs(p, relay)
+}
+
+// handleBlock hands the block over to the sync, a peer sending a block without a valid proof of work misbehaves
+// A block that can't be decoded is returned as an error
+func (s *Server) handleBlock(p *Peer, payload block) error {
+	block, err := blockchain.DecodeBlock(payload.Block)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	blockData := payload.block
-	block := blockchain.DeserializeBlock(blockData)
+	p.knownInventory.Add(block.Hash())
+
+	if !blockchain.NewProofOfWork(block).Validate() {
+		s.misbehave(p, scoreInvalidBlock, fmt.Sprintf("block %x has an invalid proof of work", block.Hash()))
+		return nil
+	}
+
+	logger.Debugf("Received block %x from %s", block.Hash(), p.addr)
+	s.receiveBlock(p, block)
+
+	return nil
+}
 
-	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+// handleInv requests the headers of the blocks and the transactions the node doesn't have yet
+// Peers not serving headers are asked for the blocks right away, oldest first, a full inventory answering getblocks is followed up
+// A transaction requested from another peer lately is not requested again
+func (s *Server) handleInv(p *Peer, payload inv) {
+	logger.Debugf("Received inventory with %d %s", len(payload.Items), payload.Kind)
 
-	fmt.Printf("Added block %x\n", block.Hash())
+	var unknown [][]byte
 
-	if len(s.blocksInTransit) > 0 {
-		blockHash := s.blocksInTransit[0]
-		s.sendGetData(payload.addrFrom, "block", blockHash)
+	for _, item := range payload.Items {
+		p.knownInventory.Add(item)
 
-		s.blocksInTransit = s.blocksInTransit[1:]
-	} else {
-		UTXOSet := chainstate.NewUTXOSet(bc)
-		UTXOSet.Reindex()
+		if !s.haveInventory(payload.Kind, item) {
+			unknown = append(unknown, item)
+		}
 	}
-}
 
-func (s *Server) handleInv(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload inv
+	if len(unknown) == 0 {
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if payload.Kind == "block" && p.version >= headersVersion {
+		s.sendGetHeaders(p)
 	}
 
-	fmt.Printf("Recevied inventory with %d %s\n", len(payload.items), payload.kind)
+	if payload.Kind == "block" && p.version < headersVersion {
+		// Peers older than headersVersion list the chain from its tip
+		slices.Reverse(unknown)
 
-	if payload.kind == "block" {
-		s.blocksInTransit = payload.items
+		for _, blockHash := range unknown {
+			s.sendGetData(p, "block", blockHash)
+		}
 
-		blockHash := payload.items[0]
-		s.sendGetData(payload.addrFrom, "block", blockHash)
+		if len(payload.Items) == maxBlocksPerInv {
+			p.continueHash = unknown[len(unknown)-1]
+		}
+	}
 
-		newInTransit := [][]byte{}
-		for _, b := range s.blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
+	if payload.Kind == "tx" {
+		for _, txID := range unknown {
+			s.txRequests[hex.EncodeToString(txID)] = time.Now()
+			s.sendGetData(p, "tx", txID)
 		}
-		s.blocksInTransit = newInTransit
 	}
+}
 
-	if payload.kind == "tx" {
-		txID := payload.items[0]
+// haveInventory tells whether the node has the block or its header, or has the transaction, an orphan one included, or requested it lately
+func (s *Server) haveInventory(kind string, id []byte) bool {
+	if kind == "block" {
+		return s.knowsBlock(id) || s.orphanBlocks[hex.EncodeToString(id)] != nil
+	}
 
-		if s.mempool[hex.EncodeToString(txID)].ID() == nil {
-			s.sendGetData(payload.addrFrom, "tx", txID)
-		}
+	txID := hex.EncodeToString(id)
+	if s.mempool[txID] != nil || s.orphanTxs[txID] != nil {
+		return true
 	}
+
+	requested, ok := s.txRequests[txID]
+
+	return ok && time.Since(requested) < txRequestTimeout
 }
 
-func (s *Server) handleGetBlocks(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload getblocks
+// handleGetAddr answers with the full nodes known to be up lately, once per connection
+func (s *Server) handleGetAddr(p *Peer) {
+	if p.answeredGetAddr {
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	p.answeredGetAddr = true
+
+	var addrs []netAddress
+	for _, ka := range s.addrBook.Fresh(maxAddrPerMessage, addrMaxAge, map[string]bool{p.addr: true}) {
+		addrs = append(addrs, netAddress{ka.Addr, ka.Services, ka.LastKnown()})
 	}
 
-	blocks := bc.GetBlockHashes()
-	s.sendInv(payload.addrFrom, "block", blocks)
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+
+	s.sendAddr(p, addrs)
 }
 
-func (s *Server) handleGetData(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload getdata
+// handleGetBlocks answers with the inventory of the blocks of the chain following the locator, lowest first
+func (s *Server) handleGetBlocks(p *Peer, payload getblocks) {
+	blocks := s.bc.GetBlockHashesAfter(payload.Locator, payload.StopHash, maxBlocksPerInv)
+	if len(blocks) == 0 {
+		return
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	for _, blockHash := range blocks {
+		p.knownInventory.Add(blockHash)
+	}
+
+	s.sendInv(p, "block", blocks)
+}
+
+// handleGetHeaders answers with the headers of the chain following the locator
+func (s *Server) handleGetHeaders(p *Peer, payload getheaders) {
+	s.sendHeaders(p, s.bc.GetHeaders(payload.Locator, payload.StopHash, maxHeadersPerMessage))
+}
+
+// handleHeaders keeps the valid headers and downloads their blocks, a full message means the peer has more to send
+// A peer sending a header that is invalid misbehaves, one not following a known header is asked for the headers leading to it
+// A light wallet hands them over to its own sync
+func (s *Server) handleHeaders(p *Peer, payload headers) {
+	if s.bc == nil {
+		s.handleLightHeaders(p, payload)
+		return
+	}
+
+	if len(payload.Headers) > maxHeadersPerMessage {
+		s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("sent %d headers at once", len(payload.Headers)))
+		return
 	}
 
-	if payload.kind == "block" {
-		block, err := bc.GetBlock([]byte(payload.id))
+	for _, h := range payload.Headers {
+		p.knownInventory.Add(h.Hash())
+
+		err := s.acceptHeader(h)
+		if err == errUnknownHeaderParent {
+			s.sendGetHeaders(p)
+			return
+		}
+
+		if err == errHeaderFromFuture {
+			logger.Warnf("Header %x from %s is too far in the future, ignoring it", h.Hash(), p.addr)
+			break
+		}
+
 		if err != nil {
+			s.misbehave(p, scoreInvalidBlock, err.Error())
 			return
 		}
 
-		s.sendBlock(payload.addrFrom, &block)
+		p.bestHeight = max(p.bestHeight, h.Height())
 	}
 
-	if payload.kind == "tx" {
-		txID := hex.EncodeToString(payload.id)
-		tx := s.mempool[txID]
-
-		s.SendTx(payload.addrFrom, tx)
-		// delete(s.mempool, txID)
+	if len(payload.Headers) == maxHeadersPerMessage {
+		s.sendGetHeaders(p)
+	} else if p == s.sync.syncPeer {
+		logger.Infof("Synced headers from %s up to height %d", p.addr, s.headerTipHeight())
+		s.sync.syncPeer = nil
 	}
+
+	s.processOrphanBlocks()
+	s.fillDownloads()
 }
 
-func (s *Server) handleTx(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload tx
+// handleGetData answers with the block or the transaction, a filtered block only goes to a peer that loaded a filter
+func (s *Server) handleGetData(p *Peer, payload getdata) {
+	if payload.Kind == "block" || payload.Kind == "cmpctblock" || payload.Kind == "filteredblock" {
+		block, err := s.bc.GetBlock(payload.ID)
+		if err != nil {
+			return
+		}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+		switch {
+		case payload.Kind == "cmpctblock":
+			s.sendCmpctBlock(p, &block)
+		case payload.Kind == "filteredblock" && p.filter != nil:
+			s.sendMerkleBlock(p, &block)
+		case payload.Kind == "block":
+			s.sendBlock(p, &block)
+		}
 	}
 
-	txData := payload.transaction
-	tx := transaction.DeserializeTransaction(txData)
-	s.mempool[hex.EncodeToString(tx.ID())] = &tx
+	if payload.Kind == "tx" {
+		txID := hex.EncodeToString(payload.ID)
 
-	if s.nodeAddress == s.knownNodes[0] {
-		for _, node := range s.knownNodes {
-			if node != s.nodeAddress && node != payload.addFrom {
-				s.sendInv(node, "tx", [][]byte{tx.ID()})
-			}
+		tx, ok := s.mempool[txID]
+		if !ok {
+			return
 		}
-	} else {
-		if len(s.mempool) >= 2 && len(s.miningAddress) > 0 {
-		MineTransactions:
-			var txs []*transaction.Transaction
 
-			for id := range s.mempool {
-				tx := s.mempool[id]
-				if bc.VerifyTransaction(tx) {
-					txs = append(txs, tx)
-				}
-			}
+		s.sendTx(p, tx)
+	}
+}
 
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
+// handleTx takes a new transaction, then the orphan transactions spending from it
+// A transaction that can't be decoded is returned as an error
+func (s *Server) handleTx(p *Peer, payload tx) error {
+	tx, err := transaction.DecodeTransaction(payload.Transaction)
+	if err != nil {
+		return err
+	}
 
-			cbTx := transaction.NewCoinbaseTX(s.miningAddress, "")
-			txs = append(txs, cbTx)
+	p.knownInventory.Add(tx.ID())
+	delete(s.txRequests, hex.EncodeToString(tx.ID()))
 
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := chainstate.NewUTXOSet(bc)
-			UTXOSet.Reindex()
+	if !s.acceptTransaction(p, &tx) {
+		return nil
+	}
 
-			fmt.Println("New block is mined!")
+	s.processOrphanTxs(tx.ID())
+	s.mineMempool()
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID())
-				delete(s.mempool, txID)
-			}
+	return nil
+}
 
-			for _, node := range s.knownNodes {
-				if node != s.nodeAddress {
-					s.sendInv(node, "block", [][]byte{newBlock.Hash()})
-				}
-			}
+// mineMempool mines the transactions of the mempool once it holds two, on a node with a mining address. The caller holds s.mu
+func (s *Server) mineMempool() {
+	if len(s.mempool) < 2 || len(s.miningAddress) == 0 {
+		return
+	}
 
-			if len(s.mempool) > 0 {
-				goto MineTransactions
-			}
+MineTransactions:
+	var txs []*transaction.Transaction
+	UTXOSet := chainstate.NewUTXOSet(s.bc)
+
+	for id := range s.mempool {
+		tx := s.mempool[id]
+
+		// A transaction spending the change of another pending one waits for its parent to be mined
+		if s.spendsMempool(tx) {
+			continue
+		}
+
+		// Peers check the block against their UTXO set, a transaction spending what another one spends is left out
+		if UTXOSet.CheckSpends(append(txs, tx)) == nil {
+			txs = append(txs, tx)
 		}
 	}
+
+	if len(txs) == 0 {
+		logger.Warnf("All transactions are invalid! Waiting for new ones...")
+		return
+	}
+
+	cbTx := transaction.NewCoinbaseTX(s.miningAddress, "", s.bc.GetBestHeight()+1)
+	txs = append(txs, cbTx)
+
+	newBlock := s.bc.MineBlock(txs)
+	UTXOSet.Reindex()
+
+	logger.Infof("New block is mined!")
+
+	for _, tx := range txs {
+		txID := hex.EncodeToString(tx.ID())
+		delete(s.mempool, txID)
+	}
+
+	s.notifyChainChanged()
+	s.relayBlock(nil, newBlock.Hash())
+
+	if len(s.mempool) > 0 {
+		goto MineTransactions
+	}
 }
 
-func (s *Server) handleVersion(request []byte, bc *blockchain.Blockchain) {
-	var buff bytes.Buffer
-	var payload verzion
+// acceptTransaction adds a new transaction to the mempool and relays it, one spending from unknown transactions becomes an orphan
+// A peer sending a transaction with invalid signatures misbehaves. The caller holds s.mu
+func (s *Server) acceptTransaction(p *Peer, tx *transaction.Transaction) bool {
+	txID := hex.EncodeToString(tx.ID())
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
+	if s.mempool[txID] != nil || s.orphanTxs[txID] != nil {
+		return false
+	}
+
+	if missing := s.missingParents(tx); len(missing) > 0 {
+		s.addOrphanTx(p, tx, missing)
+		return false
 	}
 
-	myBestHeight := bc.GetBestHeight()
-	foreignerBestHeight := payload.bestHeight
+	if !s.checkTransaction(tx) {
+		s.misbehave(p, scoreInvalidTransaction, fmt.Sprintf("transaction %x is invalid", tx.ID()))
+		return false
+	}
+
+	s.mempool[txID] = tx
+	s.relayTransaction(p, tx.ID())
+
+	return true
+}
+
+// handleVersion checks the version of a peer and acknowledges it, an inbound peer is answered with the version of the node first
+// Peers speaking a protocol older than minProtocolVersion and connections of the node to itself are dropped
+func (s *Server) handleVersion(p *Peer, payload verzion) {
+	if payload.Version < minProtocolVersion {
+		logger.Warnf("Peer %s speaks protocol version %d, older than %d, disconnecting", p.addr, payload.Version, minProtocolVersion)
+		p.disconnect()
+		return
+	}
 
-	if myBestHeight < foreignerBestHeight {
-		s.sendGetBlocks(payload.addrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		s.sendVersion(payload.addrFrom, bc)
+	if self := s.outboundPeerWithNonce(payload.Nonce); p.inbound && self != nil {
+		logger.Warnf("Connected to itself through %s, disconnecting", self.addr)
+		s.addrBook.Remove(self.addr)
+		self.disconnect()
+		p.disconnect()
+		return
 	}
 
-	// sendAddr(payload.addrFrom)
-	if !s.nodeIsKnown(payload.addrFrom) {
-		s.knownNodes = append(s.knownNodes, payload.addrFrom)
+	p.version = min(payload.Version, nodeVersion)
+	p.services = payload.Services
+	p.userAgent = payload.UserAgent
+	p.bestHeight = payload.BestHeight
+
+	if p.inbound {
+		if payload.AddrFrom != "" {
+			p.addr = payload.AddrFrom
+		}
+
+		// A peer of a local network is banned by the address it listens on, which it only tells now
+		if s.addrBook.IsBanned(p.banKey()) {
+			logger.Infof("Peer %s is banned, disconnecting", p.addr)
+			p.disconnect()
+			return
+		}
+
+		s.sendVersion(p)
 	}
+
+	s.sendVerack(p)
+	p.state = peerAwaitingVerack
+
+	logger.Debugf("Peer %s speaks version %d with %s, user agent %s", p.addr, p.version, p.services, p.userAgent)
 }
 
-func (s *Server) handleConnection(conn net.Conn, bc *blockchain.Blockchain) {
-	request, err := io.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
+// handleVerack completes the handshake
+// Full nodes exchange addresses, the node syncs from one whose chain is longer
+// Only the addresses the node dialed are recorded in the address book, an inbound peer could claim any address
+func (s *Server) handleVerack(p *Peer) {
+	p.completeHandshake()
+
+	if s.bc == nil || !p.services.Has(SFNodeNetwork) {
+		return
 	}
 
-	command := s.bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	if !p.inbound {
+		s.addrBook.Add(p.addr, p.addr)
+		s.addrBook.Connected(p.addr, p.services)
+		s.saveAddrBook()
+	}
+
+	s.advertise(p)
+	s.requestCompactBlocks(p)
+
+	if s.headerTipHeight() < p.bestHeight && p.version >= headersVersion {
+		s.startSync(p)
+	} else if s.headerTipHeight() < p.bestHeight {
+		s.sendGetBlocks(p)
+	}
+
+	s.fillDownloads()
+}
+
+func (s *Server) handlePing(p *Peer, payload []byte) {
+	p.QueueMessage("pong", payload)
+}
+
+// handleMessage decodes the payload of a message and hands it to the handler of its command
+// Until the handshake is complete only the message expected next is accepted
+// A server that was not started ignores everything else, but the headers and filtered blocks a light wallet syncs from
+func (s *Server) handleMessage(p *Peer, msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logger.Debugf("Received %s command from %s", msg.command, p.addr)
+
+	if expected, ok := p.expectedCommand(); ok && msg.command != expected {
+		s.misbehave(p, scoreHandshakeViolation, fmt.Sprintf("sent %s instead of %s during the handshake", msg.command, expected))
+		p.disconnect()
+		return
+	}
+
+	if s.bc == nil && p.state == peerEstablished && msg.command != "ping" && !s.isLightMessage(p, msg.command) {
+		return
+	}
 
-	switch command {
+	var err error
+
+	switch msg.command {
 	case "addr":
-		s.handleAddr(request)
+		var payload addr
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleAddr(p, payload)
+		}
 	case "block":
-		s.handleBlock(request, bc)
+		var payload block
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			err = s.handleBlock(p, payload)
+		}
+	case "blocktxn":
+		var payload blocktxn
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			err = s.handleBlockTxn(p, payload)
+		}
+	case "cmpctblock":
+		var payload cmpctblock
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			err = s.handleCmpctBlock(p, payload)
+		}
 	case "inv":
-		s.handleInv(request, bc)
+		var payload inv
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleInv(p, payload)
+		}
+	case "filteradd":
+		var payload filteradd
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleFilterAdd(p, payload)
+		}
+	case "filterclear":
+		s.handleFilterClear(p)
+	case "filterload":
+		var payload filterload
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleFilterLoad(p, payload)
+		}
+	case "getaddr":
+		s.handleGetAddr(p)
+	case "getblocktxn":
+		var payload getblocktxn
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleGetBlockTxn(p, payload)
+		}
 	case "getblocks":
-		s.handleGetBlocks(request, bc)
+		var payload getblocks
+		if len(msg.payload) > 0 {
+			err = decodePayload(msg.payload, &payload)
+		}
+		if err == nil {
+			s.handleGetBlocks(p, payload)
+		}
+	case "getheaders":
+		var payload getheaders
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleGetHeaders(p, payload)
+		}
+	case "headers":
+		var payload headers
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleHeaders(p, payload)
+		}
 	case "getdata":
-		s.handleGetData(request, bc)
+		var payload getdata
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleGetData(p, payload)
+		}
+	case "merkleblock":
+		var payload merkleblock
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleMerkleBlock(p, payload)
+		}
 	case "tx":
-		s.handleTx(request, bc)
+		var payload tx
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			err = s.handleTx(p, payload)
+		}
 	case "version":
-		s.handleVersion(request, bc)
+		var payload verzion
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleVersion(p, payload)
+		}
+	case "verack":
+		s.handleVerack(p)
+	case "ping":
+		s.handlePing(p, msg.payload)
+	case "sendcmpct":
+		var payload sendcmpct
+		if err = decodePayload(msg.payload, &payload); err == nil {
+			s.handleSendCmpct(p, payload)
+		}
+	case "pong":
 	default:
-		fmt.Println("Unknown command!")
+		logger.Warnf("Unknown command %s!", msg.command)
+	}
+
+	if err != nil {
+		s.misbehave(p, scoreMalformedMessage, fmt.Sprintf("sent a malformed %s: %s", msg.command, err))
+		p.disconnect()
+	}
+}
+
+func decodePayload(payload []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(v)
+}
+
+// missingParents returns the IDs of the transactions the inputs spend from that are neither in the chain nor in the mempool
+func (s *Server) missingParents(tx *transaction.Transaction) [][]byte {
+	var missing [][]byte
+	seen := make(map[string]bool)
+
+	for _, vin := range tx.Vin() {
+		prevTxID := hex.EncodeToString(vin.TxId())
+		if seen[prevTxID] || s.mempool[prevTxID] != nil {
+			continue
+		}
+
+		seen[prevTxID] = true
+
+		if _, err := s.bc.FindTransaction(vin.TxId()); err != nil {
+			missing = append(missing, vin.TxId())
+		}
+	}
+
+	return missing
+}
+
+// checkTransaction verifies the signatures of a transaction whose inputs spend outputs of the chain or the mempool
+func (s *Server) checkTransaction(tx *transaction.Transaction) bool {
+	if tx.IsCoinbase() {
+		return false
+	}
+
+	prevTxs := make(map[string]transaction.Transaction)
+
+	for _, vin := range tx.Vin() {
+		prevTxID := hex.EncodeToString(vin.TxId())
+
+		prevTx, err := s.bc.FindTransaction(vin.TxId())
+		if err != nil {
+			mempoolTx, ok := s.mempool[prevTxID]
+			if !ok {
+				return false
+			}
+
+			prevTx = *mempoolTx
+		}
+
+		if vin.Vout() < 0 || vin.Vout() >= len(prevTx.Vout()) {
+			return false
+		}
+
+		prevTxs[prevTxID] = prevTx
+	}
+
+	return tx.Verify(prevTxs)
+}
+
+// spendsMempool tells whether the transaction spends an output of a transaction still in the mempool
+func (s *Server) spendsMempool(tx *transaction.Transaction) bool {
+	for _, vin := range tx.Vin() {
+		if _, ok := s.mempool[hex.EncodeToString(vin.TxId())]; ok {
+			return true
+		}
 	}
 
-	conn.Close()
+	return false
 }
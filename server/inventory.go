@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"time"
+)
+
+const (
+	// maxKnownInventory is how many transaction and block IDs are remembered per peer
+	maxKnownInventory = 5000
+	// Transactions are announced in batches at random intervals averaging these, so the first peer announcing one
+	// does not give away the node it came from. Inbound peers wait longer since anyone can connect to the node
+	inboundTrickleInterval  = 5 * time.Second
+	outboundTrickleInterval = 2 * time.Second
+	trickleTick             = 100 * time.Millisecond
+	// txRequestTimeout is how long a transaction requested from one peer is not requested from another
+	txRequestTimeout = time.Minute
+)
+
+// inventorySet is a set of transaction and block IDs that forgets the oldest ones past its limit
+type inventorySet struct {
+	items map[string]bool
+	order []string
+	limit int
+}
+
+func newInventorySet(limit int) *inventorySet {
+	return &inventorySet{items: make(map[string]bool), limit: limit}
+}
+
+// Add adds the ID to the set
+func (is *inventorySet) Add(id []byte) {
+	key := hex.EncodeToString(id)
+	if is.items[key] {
+		return
+	}
+
+	if len(is.order) == is.limit {
+		delete(is.items, is.order[0])
+		is.order = is.order[1:]
+	}
+
+	is.items[key] = true
+	is.order = append(is.order, key)
+}
+
+// Has tells whether the ID is in the set
+func (is *inventorySet) Has(id []byte) bool {
+	return is.items[hex.EncodeToString(id)]
+}
+
+// scheduleTrickle picks when the queued transactions are announced to the peer next
+func (p *Peer) scheduleTrickle() {
+	interval := outboundTrickleInterval
+	if p.inbound {
+		interval = inboundTrickleInterval
+	}
+
+	p.nextTrickle = time.Now().Add(time.Duration(rand.ExpFloat64() * float64(interval)))
+}
+
+// relayTransaction queues the transaction for the peers that don't know it yet, from is the peer it came from
+// Peers that loaded a bloom filter only get the transactions matching it. The caller holds s.mu
+func (s *Server) relayTransaction(from *Peer, txID []byte) {
+	for p := range s.peers {
+		if p == from || p.state != peerEstablished || p.knownInventory.Has(txID) || !s.matchesFilter(p, txID) {
+			continue
+		}
+
+		p.knownInventory.Add(txID)
+		p.txQueue = append(p.txQueue, txID)
+	}
+}
+
+// relayBlock announces the block to the peers that don't know it yet right away, from is the peer it came from
+// Peers that asked for it get the compact block instead of an inv. The caller holds s.mu
+func (s *Server) relayBlock(from *Peer, hash []byte) {
+	for p := range s.peers {
+		if p == from || p.state != peerEstablished || p.knownInventory.Has(hash) {
+			continue
+		}
+
+		p.knownInventory.Add(hash)
+
+		if !p.wantsCompact {
+			s.sendInv(p, "block", [][]byte{hash})
+			continue
+		}
+
+		block, err := s.bc.GetBlock(hash)
+		if err != nil {
+			continue
+		}
+
+		s.sendCmpctBlock(p, &block)
+	}
+}
+
+// trickle announces the queued transactions of every peer whose turn came, shuffled, until the node stops
+// Transactions that left the mempool meanwhile are not announced
+func (s *Server) trickle() {
+	ticker := time.NewTicker(trickleTick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+
+		now := time.Now()
+
+		for p := range s.peers {
+			if len(p.txQueue) == 0 || now.Before(p.nextTrickle) {
+				continue
+			}
+
+			var txIDs [][]byte
+			for _, txID := range p.txQueue {
+				if s.mempool[hex.EncodeToString(txID)] != nil {
+					txIDs = append(txIDs, txID)
+				}
+			}
+
+			rand.Shuffle(len(txIDs), func(i, j int) {
+				txIDs[i], txIDs[j] = txIDs[j], txIDs[i]
+			})
+
+			if len(txIDs) > 0 {
+				s.sendInv(p, "tx", txIDs)
+			}
+
+			p.txQueue = nil
+			p.scheduleTrickle()
+		}
+
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+var (
+	errNoBloomNode      = errors.New("ERROR: No known node serving filtered blocks could be reached")
+	errLightPeerGone    = errors.New("ERROR: The node disconnected before the light wallet was synced")
+	errLightSyncStalled = errors.New("ERROR: The node stopped answering before the light wallet was synced")
+)
+
+// LightWallet is a wallet keeping the headers of the chain only, with the transactions full nodes prove to be in its blocks
+type LightWallet interface {
+	// Locator returns the block locator of the highest header
+	Locator() [][]byte
+	// AddHeaders adds headers following the header chain
+	AddHeaders(headers []*blockchain.BlockHeader) error
+	// Filter returns the bloom filter matching the transactions of the wallet
+	Filter() *utils.BloomFilter
+	// UnscannedBlocks returns the hashes of the blocks not scanned for the transactions of the wallet yet, lowest first
+	UnscannedBlocks() [][]byte
+	// AddMerkleBlock scans the next unscanned block with the transactions matching the filter and the tree proving them
+	AddMerkleBlock(header *blockchain.BlockHeader, tree *utils.PartialMerkleTree, txs []*transaction.Transaction) error
+}
+
+// lightSync is the state of the sync of a light wallet from a single node serving filtered blocks
+// The headers come first, then the filtered blocks are requested in order, at most maxBlocksInFlightPerPeer at once
+type lightSync struct {
+	wallet LightWallet
+	peer   *Peer
+	// scanning is set once every header arrived, pending holds the blocks to request next
+	scanning bool
+	pending  [][]byte
+	inFlight int
+	// progress is signaled whenever a message moves the sync on, done gets its outcome
+	progress chan struct{}
+	done     chan error
+}
+
+func newLightSync(wallet LightWallet, p *Peer) *lightSync {
+	return &lightSync{wallet: wallet, peer: p, progress: make(chan struct{}, 1), done: make(chan error, 1)}
+}
+
+func (ls *lightSync) advance() {
+	select {
+	case ls.progress <- struct{}{}:
+	default:
+	}
+}
+
+func (ls *lightSync) finish(err error) {
+	select {
+	case ls.done <- err:
+	default:
+	}
+}
+
+// SyncLight syncs the light wallet from the first known node serving filtered blocks that gets it synced
+// The wallet gets the headers of the chain and the transactions of the blocks matching its filter, with their proofs
+func (s *Server) SyncLight(wallet LightWallet) error {
+	s.loadAddrBook()
+
+	err := errNoBloomNode
+
+	for _, addr := range s.KnownNodes() {
+		if addr == s.nodeAddress {
+			continue
+		}
+
+		err = s.syncLightFrom(addr, wallet)
+		if err == nil {
+			return nil
+		}
+
+		logger.Warnf("Syncing the light wallet from %s failed: %s", addr, err)
+	}
+
+	return err
+}
+
+// syncLightFrom loads the filter of the wallet on the node at the address and syncs the wallet from it
+// A node not answering within headersTimeout is given up on
+func (s *Server) syncLightFrom(addr string, wallet LightWallet) error {
+	p, err := s.dial(addr)
+	if err != nil {
+		return err
+	}
+
+	defer p.disconnect()
+
+	select {
+	case <-p.handshake:
+	case <-p.quit:
+		return errLightPeerGone
+	}
+
+	s.mu.Lock()
+
+	if !p.services.Has(SFNodeBloom) || p.version < bloomFiltersVersion {
+		s.mu.Unlock()
+		return errNoBloomNode
+	}
+
+	ls := newLightSync(wallet, p)
+	s.light = ls
+	s.sendFilterLoad(p, wallet.Filter())
+	s.sendGetHeaders(p)
+
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.light = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case err := <-ls.done:
+			return err
+		case <-ls.progress:
+		case <-p.quit:
+			return errLightPeerGone
+		case <-time.After(headersTimeout):
+			return errLightSyncStalled
+		}
+	}
+}
+
+// isLightMessage tells whether the message is one the light wallet syncing from the peer waits for. The caller holds s.mu
+func (s *Server) isLightMessage(p *Peer, command string) bool {
+	return s.light != nil && s.light.peer == p && (command == "headers" || command == "merkleblock")
+}
+
+// handleLightHeaders adds the headers to the light wallet, a full message means the peer has more to send
+// Once every header arrived the blocks not scanned yet are requested filtered. The caller holds s.mu
+func (s *Server) handleLightHeaders(p *Peer, payload headers) {
+	ls := s.light
+	if ls.scanning {
+		return
+	}
+
+	if len(payload.Headers) > maxHeadersPerMessage {
+		ls.finish(fmt.Errorf("ERROR: %s sent %d headers at once", p.addr, len(payload.Headers)))
+		return
+	}
+
+	err := ls.wallet.AddHeaders(payload.Headers)
+	if err != nil {
+		ls.finish(err)
+		return
+	}
+
+	ls.advance()
+
+	if len(payload.Headers) == maxHeadersPerMessage {
+		s.sendGetHeaders(p)
+		return
+	}
+
+	ls.scanning = true
+	ls.pending = ls.wallet.UnscannedBlocks()
+	logger.Infof("Synced headers from %s, scanning %d blocks", p.addr, len(ls.pending))
+
+	s.requestFilteredBlocks()
+}
+
+// handleMerkleBlock hands the filtered block over to the light wallet, which checks that it proves its transactions
+// Filtered blocks that were not requested are ignored, a malformed one ends the sync with an error. The caller holds s.mu
+func (s *Server) handleMerkleBlock(p *Peer, payload merkleblock) {
+	ls := s.light
+	if ls == nil || ls.peer != p || ls.inFlight == 0 {
+		return
+	}
+
+	var txs []*transaction.Transaction
+	for _, data := range payload.Transactions {
+		tx, err := transaction.DecodeTransaction(data)
+		if err != nil {
+			ls.finish(fmt.Errorf("ERROR: %s sent a filtered block with a malformed transaction: %s", p.addr, err))
+			return
+		}
+
+		txs = append(txs, &tx)
+	}
+
+	err := ls.wallet.AddMerkleBlock(payload.Header, payload.Tree, txs)
+	if err != nil {
+		ls.finish(err)
+		return
+	}
+
+	ls.inFlight--
+	ls.advance()
+
+	s.requestFilteredBlocks()
+}
+
+// requestFilteredBlocks requests the next pending blocks filtered, the sync is done once none is left. The caller holds s.mu
+func (s *Server) requestFilteredBlocks() {
+	ls := s.light
+
+	for ls.inFlight < maxBlocksInFlightPerPeer && len(ls.pending) > 0 {
+		s.sendGetData(ls.peer, "filteredblock", ls.pending[0])
+		ls.pending = ls.pending[1:]
+		ls.inFlight++
+	}
+
+	if ls.inFlight == 0 {
+		ls.finish(nil)
+	}
+}
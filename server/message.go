@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	checksumLength = 4
+	headerLength   = 4 + commandLength + 4 + checksumLength
+	// maxPayloadLength bounds what a peer can make the node allocate for one message
+	maxPayloadLength = 32 * 1024 * 1024
+)
+
+var (
+	errWrongMagic      = errors.New("message belongs to another network")
+	errPayloadTooLarge = errors.New("message payload is too large")
+	errBadChecksum     = errors.New("message payload does not match its checksum")
+)
+
+// message is a command and its payload, framed on the wire as
+// network magic (4 bytes) | command (12 bytes, zero-padded) | payload length (4 bytes) | payload checksum (4 bytes) | payload
+type message struct {
+	command string
+	payload []byte
+}
+
+// writeMessage writes the message with its header to the connection
+func writeMessage(w io.Writer, msg *message) error {
+	if len(msg.command) > commandLength {
+		return fmt.Errorf("command %s is longer than %d bytes", msg.command, commandLength)
+	}
+
+	frame := make([]byte, 0, headerLength+len(msg.payload))
+	frame = binary.LittleEndian.AppendUint32(frame, chaincfg.ActiveParams().Magic())
+	frame = append(frame, commandToBytes(msg.command)...)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(msg.payload)))
+	frame = append(frame, utils.Checksum(msg.payload)...)
+	frame = append(frame, msg.payload...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// readMessage reads the next message from the connection and checks its header
+func readMessage(r io.Reader) (*message, error) {
+	header := make([]byte, headerLength)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(header[:4]) != chaincfg.ActiveParams().Magic() {
+		return nil, errWrongMagic
+	}
+
+	command := bytesToCommand(header[4 : 4+commandLength])
+	length := binary.LittleEndian.Uint32(header[4+commandLength:])
+	checksum := header[headerLength-checksumLength:]
+
+	if length > maxPayloadLength {
+		return nil, errPayloadTooLarge
+	}
+
+	payload := make([]byte, length)
+
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(checksum, utils.Checksum(payload)) {
+		return nil, errBadChecksum
+	}
+
+	return &message{command, payload}, nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+)
+
+const (
+	// Orphans wait for their parents up to orphanExpiry, the oldest ones are dropped when a pool is full
+	maxOrphanBlocks = 100
+	maxOrphanTxs    = 100
+	orphanExpiry    = 20 * time.Minute
+	// maxOrphanTxSize is the largest serialized transaction kept as an orphan, a peer can't fill the pool with big ones
+	maxOrphanTxSize = 100000
+	// maxOrphanBlocksSize bounds the serialized size of the orphan blocks together, blocks can be as large as a message
+	maxOrphanBlocksSize = 64 * 1024 * 1024
+)
+
+// orphanBlock is a block whose parent the node doesn't know yet, from is the peer that sent it
+type orphanBlock struct {
+	block    *blockchain.Block
+	size     int
+	from     *Peer
+	received time.Time
+}
+
+// orphanTx is a transaction spending outputs of transactions the node doesn't know yet, from is the peer that sent it
+type orphanTx struct {
+	tx       *transaction.Transaction
+	from     *Peer
+	received time.Time
+}
+
+// addOrphanBlock keeps the block until its parent arrives and asks the peer that sent it for the blocks leading to it
+// The oldest orphans make room when the pool holds maxOrphanBlocks or maxOrphanBlocksSize bytes. The caller holds s.mu
+func (s *Server) addOrphanBlock(p *Peer, b *blockchain.Block) {
+	key := hex.EncodeToString(b.Hash())
+	if s.orphanBlocks[key] != nil {
+		return
+	}
+
+	size := len(b.Serialize())
+	if size > maxOrphanBlocksSize {
+		logger.Debugf("Dropped orphan block %x, it is too large", b.Hash())
+		return
+	}
+
+	s.expireOrphanBlocks()
+
+	for len(s.orphanBlocks) >= maxOrphanBlocks || s.orphanBlocksSize+size > maxOrphanBlocksSize {
+		var oldest string
+		for k, o := range s.orphanBlocks {
+			if oldest == "" || o.received.Before(s.orphanBlocks[oldest].received) {
+				oldest = k
+			}
+		}
+
+		s.removeOrphanBlock(oldest)
+	}
+
+	s.orphanBlocks[key] = &orphanBlock{b, size, p, time.Now()}
+	s.orphanBlocksSize += size
+	logger.Infof("Block %x from %s is an orphan, %d orphan blocks of %d bytes now", b.Hash(), p.addr, len(s.orphanBlocks), s.orphanBlocksSize)
+
+	s.requestBlocks(p)
+}
+
+// processOrphanBlocks hands over the orphan blocks whose parent the node knows now, until none is left. The caller holds s.mu
+func (s *Server) processOrphanBlocks() {
+	s.expireOrphanBlocks()
+
+	for found := true; found; {
+		found = false
+
+		for key, o := range s.orphanBlocks {
+			if !s.knowsBlock(o.block.PrevBlockHash()) {
+				continue
+			}
+
+			s.removeOrphanBlock(key)
+			s.acceptBlock(o.from, o.block)
+			found = true
+		}
+	}
+}
+
+// expireOrphanBlocks drops the orphan blocks older than orphanExpiry. The caller holds s.mu
+func (s *Server) expireOrphanBlocks() {
+	for key, o := range s.orphanBlocks {
+		if time.Since(o.received) > orphanExpiry {
+			s.removeOrphanBlock(key)
+		}
+	}
+}
+
+// removeOrphanBlock drops the orphan block and its bytes from the pool. The caller holds s.mu
+func (s *Server) removeOrphanBlock(key string) {
+	s.orphanBlocksSize -= s.orphanBlocks[key].size
+	delete(s.orphanBlocks, key)
+}
+
+// knowsBlock tells whether the node has the block or its header. The caller holds s.mu
+func (s *Server) knowsBlock(hash []byte) bool {
+	if s.sync.headers[hex.EncodeToString(hash)] != nil {
+		return true
+	}
+
+	_, err := s.bc.GetBlock(hash)
+
+	return err == nil
+}
+
+// addOrphanTx keeps the transaction until the transactions it spends from arrive and asks the peer that sent it for them
+// Transactions larger than maxOrphanTxSize are dropped. The caller holds s.mu
+func (s *Server) addOrphanTx(p *Peer, tx *transaction.Transaction, missing [][]byte) {
+	txID := hex.EncodeToString(tx.ID())
+	if s.orphanTxs[txID] != nil {
+		return
+	}
+
+	if len(tx.Serialize()) > maxOrphanTxSize {
+		logger.Debugf("Dropped orphan transaction %s, it is too large", txID)
+		return
+	}
+
+	s.expireOrphanTxs()
+
+	if len(s.orphanTxs) >= maxOrphanTxs {
+		var oldest string
+		for id, o := range s.orphanTxs {
+			if oldest == "" || o.received.Before(s.orphanTxs[oldest].received) {
+				oldest = id
+			}
+		}
+
+		delete(s.orphanTxs, oldest)
+	}
+
+	s.orphanTxs[txID] = &orphanTx{tx, p, time.Now()}
+	logger.Debugf("Transaction %s from %s is an orphan, %d orphan transactions now", txID, p.addr, len(s.orphanTxs))
+
+	// The requests are not recorded, the peer may not have the parents and another one announcing them is asked too
+	for _, parentID := range missing {
+		s.sendGetData(p, "tx", parentID)
+	}
+}
+
+// processOrphanTxs hands over the orphan transactions spending from the transaction, and then the ones spending from those
+// The caller holds s.mu
+func (s *Server) processOrphanTxs(txID []byte) {
+	s.expireOrphanTxs()
+
+	parents := [][]byte{txID}
+
+	for len(parents) > 0 {
+		parentID := hex.EncodeToString(parents[0])
+		parents = parents[1:]
+
+		for id, o := range s.orphanTxs {
+			if !spendsFrom(o.tx, parentID) {
+				continue
+			}
+
+			delete(s.orphanTxs, id)
+
+			if s.acceptTransaction(o.from, o.tx) {
+				parents = append(parents, o.tx.ID())
+			}
+		}
+	}
+}
+
+// expireOrphanTxs drops the orphan transactions older than orphanExpiry. The caller holds s.mu
+func (s *Server) expireOrphanTxs() {
+	for id, o := range s.orphanTxs {
+		if time.Since(o.received) > orphanExpiry {
+			delete(s.orphanTxs, id)
+		}
+	}
+}
+
+// spendsFrom tells whether the transaction spends an output of the transaction with the hex-encoded ID
+func spendsFrom(tx *transaction.Transaction, txID string) bool {
+	for _, vin := range tx.Vin() {
+		if hex.EncodeToString(vin.TxId()) == txID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	// sendQueueLength is how many messages may wait for a peer, one that lets its queue fill up is dropped as too slow
+	sendQueueLength = 256
+	writeTimeout    = 30 * time.Second
+	// pingInterval keeps quiet connections alive, idleTimeout drops a peer that sent nothing for that long
+	pingInterval     = 2 * time.Minute
+	idleTimeout      = 5 * time.Minute
+	dialTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+type peerState int
+
+// A peer sends its version first, then acknowledges the version of the node with a verack
+const (
+	// peerAwaitingVersion only accepts a version message
+	peerAwaitingVersion peerState = iota
+	// peerAwaitingVerack only accepts a verack message
+	peerAwaitingVerack
+	peerEstablished
+)
+
+// Peer is a long-lived connection to another node
+// Messages are read and written by their own loops, outgoing ones wait in a queue so a slow peer only holds up itself
+type Peer struct {
+	conn net.Conn
+	// addr is the address the node dialed, or the one an inbound peer claims to listen on, which is not checked
+	addr string
+	// host is the IP address the peer connects from, its misbehaviour is recorded under it unless it is local
+	host    string
+	inbound bool
+	state   peerState
+	// nonce is the one of the version the node sent on this connection
+	nonce uint64
+	// version is the protocol version both sides speak, the lower of the two
+	version    int
+	services   ServiceFlag
+	userAgent  string
+	bestHeight int
+
+	// knownAddrs holds the addresses the peer sent or was sent, which are not relayed to it again
+	knownAddrs map[string]bool
+	// addrTokens is how many addresses the peer may send, refilled since addrTokensAt
+	addrTokens   float64
+	addrTokensAt time.Time
+	// answeredGetAddr is set once the node answered a getaddr, the next ones are ignored
+	answeredGetAddr bool
+	// knownInventory holds the blocks and transactions the peer has or was told about
+	knownInventory *inventorySet
+	// txQueue holds the transactions to announce to the peer at nextTrickle
+	txQueue     [][]byte
+	nextTrickle time.Time
+	// continueHash is the last block of a full inventory answering getblocks, the next ones are asked for once it arrives
+	continueHash []byte
+	// wantsCompact is set when the peer asked for new blocks to be pushed as compact blocks, pushesCompact when the node asked it to
+	wantsCompact  bool
+	pushesCompact bool
+	// filter is the bloom filter the peer loaded, only the transactions matching it are relayed to the peer
+	filter *utils.BloomFilter
+
+	sendQueue chan *message
+	// handshake is closed once the version messages are exchanged
+	handshake chan struct{}
+	quit      chan struct{}
+	// done is closed once the write loop stopped, every queued message is sent by then unless the peer failed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{
+		conn:           conn,
+		addr:           addr,
+		host:           hostOf(conn.RemoteAddr().String()),
+		inbound:        inbound,
+		state:          peerAwaitingVersion,
+		knownAddrs:     make(map[string]bool),
+		addrTokens:     maxAddrAnnouncement,
+		addrTokensAt:   time.Now(),
+		knownInventory: newInventorySet(maxKnownInventory),
+		sendQueue:      make(chan *message, sendQueueLength),
+		handshake:      make(chan struct{}),
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// banKey returns what the misbehaviour of the peer is recorded under, the IP address it connects from
+// Nodes of a loopback or private network share it, such a peer is told apart by the address it listens on
+func (p *Peer) banKey() string {
+	if !isLocalHost(p.host) {
+		return p.host
+	}
+
+	return banKey(p.addr)
+}
+
+func (p *Peer) Addr() string {
+	return p.addr
+}
+
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+func (p *Peer) Version() int {
+	return p.version
+}
+
+func (p *Peer) Services() ServiceFlag {
+	return p.services
+}
+
+func (p *Peer) UserAgent() string {
+	return p.userAgent
+}
+
+func (p *Peer) BestHeight() int {
+	return p.bestHeight
+}
+
+// start runs the read and write loops, handle is called for every message read and onClose once the peer is gone
+// A peer that does not complete the handshake in time is disconnected
+func (p *Peer) start(handle func(*Peer, *message), onClose func(*Peer)) {
+	go p.writeLoop()
+
+	go func() {
+		p.readLoop(handle)
+		onClose(p)
+	}()
+
+	go func() {
+		select {
+		case <-p.handshake:
+		case <-p.quit:
+		case <-time.After(handshakeTimeout):
+			logger.Warnf("Peer %s did not complete the handshake, disconnecting", p.addr)
+			p.disconnect()
+		}
+	}()
+}
+
+// QueueMessage queues a message to the peer without waiting, a peer whose queue is full is disconnected
+// Callers hold s.mu, so waiting on one slow peer would stall every other one
+func (p *Peer) QueueMessage(command string, payload []byte) {
+	select {
+	case p.sendQueue <- &message{command, payload}:
+	case <-p.quit:
+	default:
+		logger.Warnf("Peer %s is too slow, disconnecting", p.addr)
+		p.disconnect()
+	}
+}
+
+// closeAfterQueued disconnects once the messages queued so far are sent
+func (p *Peer) closeAfterQueued() {
+	select {
+	case p.sendQueue <- nil:
+	case <-p.quit:
+	}
+}
+
+func (p *Peer) disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// expectedCommand returns the only command accepted while the handshake is in progress
+func (p *Peer) expectedCommand() (string, bool) {
+	switch p.state {
+	case peerAwaitingVersion:
+		return "version", true
+	case peerAwaitingVerack:
+		return "verack", true
+	}
+
+	return "", false
+}
+
+func (p *Peer) completeHandshake() {
+	p.state = peerEstablished
+	close(p.handshake)
+}
+
+func (p *Peer) readLoop(handle func(*Peer, *message)) {
+	defer p.disconnect()
+
+	for {
+		err := p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if err != nil {
+			return
+		}
+
+		msg, err := readMessage(p.conn)
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				logger.Debugf("Peer %s disconnected: %s", p.addr, err)
+			}
+
+			return
+		}
+
+		handle(p, msg)
+	}
+}
+
+func (p *Peer) writeLoop() {
+	defer close(p.done)
+
+	pings := time.NewTicker(pingInterval)
+	defer pings.Stop()
+
+	for {
+		var msg *message
+
+		select {
+		case msg = <-p.sendQueue:
+			if msg == nil {
+				p.disconnect()
+				return
+			}
+		case <-pings.C:
+			msg = &message{"ping", gobEncode(ping{randomNonce()})}
+		case <-p.quit:
+			return
+		}
+
+		err := p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err == nil {
+			err = writeMessage(p.conn, msg)
+		}
+
+		if err != nil {
+			logger.Debugf("Sending %s to %s failed: %s", msg.command, p.addr, err)
+			p.disconnect()
+			return
+		}
+	}
+}
+
+func randomNonce() uint64 {
+	var nonce [8]byte
+
+	_, err := rand.Read(nonce[:])
+	if err != nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(nonce[:])
+}
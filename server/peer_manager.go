@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+)
+
+const (
+	// maintenanceInterval is how often the node tops up its outbound connections and saves its address book
+	maintenanceInterval = 30 * time.Second
+	// banThreshold is the misbehaviour score that gets a peer banned
+	banThreshold = 100
+)
+
+var errHostBanned = errors.New("ERROR: The host of the address is banned")
+
+// Misbehaviour scores, a peer reaching banThreshold is banned
+const (
+	scoreHandshakeViolation = 10
+	scoreMalformedMessage   = 20
+	scoreInvalidTransaction = 100
+	scoreInvalidBlock       = 100
+)
+
+// maintainPeers refreshes the connections of the node right away, then every maintenanceInterval or when asked to
+func (s *Server) maintainPeers() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		s.refreshPeers()
+
+		select {
+		case <-ticker.C:
+		case <-s.refreshRequests:
+		}
+	}
+}
+
+// requestRefresh has the connections refreshed without waiting for the next tick, such as when new addresses came in
+func (s *Server) requestRefresh() {
+	select {
+	case s.refreshRequests <- struct{}{}:
+	default:
+	}
+}
+
+// refreshPeers picks up the changes add_peer and ban_peer made to the address book, drops the banned peers
+// and dials addresses until the node has maxOutbound outbound connections
+func (s *Server) refreshPeers() {
+	s.mu.Lock()
+
+	s.reloadAddrBook()
+	s.addrBook.Prune(addrMaxAge)
+
+	for p := range s.peers {
+		if s.addrBook.IsBanned(p.banKey()) {
+			logger.Infof("Peer %s is banned, disconnecting", p.addr)
+			p.disconnect()
+		}
+	}
+
+	var candidates []string
+	if missing := s.maxOutbound - s.peerCount(false); missing > 0 {
+		candidates = s.addrBook.Candidates(missing, s.connectedAddrs())
+	}
+
+	for _, addr := range candidates {
+		s.addrBook.Attempt(addr)
+	}
+
+	s.saveAddrBook()
+	s.mu.Unlock()
+
+	for _, addr := range candidates {
+		s.dial(addr)
+	}
+}
+
+// dial opens a connection to the address and starts the handshake
+func (s *Server) dial(addr string) (*Peer, error) {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		logger.Warnf("%s is not available", addr)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := newPeer(conn, addr, false)
+	if s.addrBook.IsBanned(p.banKey()) {
+		logger.Infof("%s is banned, disconnecting", addr)
+		conn.Close()
+		return nil, errHostBanned
+	}
+
+	s.addPeer(p)
+	s.sendVersion(p)
+
+	return p, nil
+}
+
+// misbehave adds to the misbehaviour score of the peer, kept by its ban key, and bans the key once the score reaches
+// banThreshold. The caller holds s.mu
+func (s *Server) misbehave(p *Peer, score int, reason string) {
+	key := p.banKey()
+	total := s.addrBook.Misbehave(key, score)
+	logger.Warnf("Peer %s misbehaved, %s. The score of %s is %d now", p.addr, reason, key, total)
+
+	if total >= banThreshold {
+		s.ban(key, reason)
+	}
+}
+
+// ban bans the host, or the address on a local network, for banDuration and disconnects the peers it stands for
+// The caller holds s.mu
+func (s *Server) ban(key, reason string) {
+	s.addrBook.Ban(key, time.Now().Add(s.banDuration), reason)
+	logger.Warnf("Banned %s for %s", key, s.banDuration)
+
+	for p := range s.peers {
+		if p.banKey() == key {
+			p.disconnect()
+		}
+	}
+
+	// A server that was not started does not keep an address book
+	if s.bc != nil {
+		s.saveAddrBook()
+	}
+}
+
+// reloadAddrBook merges the address book file into the one of the node when someone else wrote it. The caller holds s.mu
+func (s *Server) reloadAddrBook() {
+	if !s.addrBook.FileChanged(s.nodeId) {
+		return
+	}
+
+	book, err := NewAddrBook(s.nodeId)
+	if err != nil {
+		logger.Warnf("Can't reload the address book: %s", err)
+		return
+	}
+
+	s.addrBook.Merge(book)
+}
+
+// saveAddrBook saves the address book without losing the changes made to the file meanwhile. The caller holds s.mu
+func (s *Server) saveAddrBook() {
+	s.reloadAddrBook()
+	s.addrBook.SaveToFile(s.nodeId)
+}
+
+// peerCount returns the number of inbound or outbound peers. The caller holds s.mu
+func (s *Server) peerCount(inbound bool) int {
+	count := 0
+
+	for p := range s.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+
+	return count
+}
+
+// connectedAddrs returns the addresses the node is connected to, its own one included. The caller holds s.mu
+func (s *Server) connectedAddrs() map[string]bool {
+	addrs := map[string]bool{s.nodeAddress: true}
+
+	for p := range s.peers {
+		addrs[p.addr] = true
+	}
+
+	return addrs
+}
@@ -1,93 +1,95 @@
 package server
 
 import (
-	"bytes"
-	"fmt"
-	"io"
-	"log"
-	"net"
+	"time"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
 
-func (s *Server) sendVersion(addr string, blockchain *blockchain.Blockchain) {
-	bestHeight := blockchain.GetBestHeight()
-	payload := s.gobEncode(verzion{nodeVersion, bestHeight, s.nodeAddress})
+func (s *Server) sendVersion(p *Peer) {
+	p.nonce = randomNonce()
+	payload := gobEncode(verzion{nodeVersion, s.services(), time.Now().Unix(), p.addr, s.nodeAddress, p.nonce, userAgent, s.bestHeight()})
 
-	request := append(s.commandToBytes("version"), payload...)
+	p.QueueMessage("version", payload)
+}
 
-	s.sendData(addr, request)
+func (s *Server) sendVerack(p *Peer) {
+	p.QueueMessage("verack", nil)
 }
 
-func (s *Server) sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
+func (s *Server) sendAddr(p *Peer, addrs []netAddress) {
+	for _, a := range addrs {
+		p.knownAddrs[a.Addr] = true
+	}
 
-		for _, node := range s.knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
+	p.QueueMessage("addr", gobEncode(addr{addrs}))
+}
 
-		s.knownNodes = updatedNodes
+func (s *Server) sendGetAddr(p *Peer) {
+	p.QueueMessage("getaddr", nil)
+}
 
-		return
-	}
+func (s *Server) sendBlock(p *Peer, b *blockchain.Block) {
+	p.QueueMessage("block", gobEncode(block{b.Serialize()}))
+}
 
-	defer conn.Close()
+func (s *Server) sendInv(p *Peer, kind string, items [][]byte) {
+	p.QueueMessage("inv", gobEncode(inv{kind, items}))
+}
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
-	}
+func (s *Server) sendGetBlocks(p *Peer) {
+	p.QueueMessage("getblocks", gobEncode(getblocks{s.locator(), nil}))
 }
 
-func (s *Server) sendAddr(address string) {
-	nodes := addr{s.knownNodes}
-	nodes.addrList = append(nodes.addrList, s.nodeAddress)
-	payload := s.gobEncode(nodes)
-	request := append(s.commandToBytes("addr"), payload...)
+func (s *Server) sendGetHeaders(p *Peer) {
+	if p == s.sync.syncPeer {
+		s.sync.headersRequestedAt = time.Now()
+	}
 
-	s.sendData(address, request)
+	p.QueueMessage("getheaders", gobEncode(getheaders{s.locator(), nil}))
 }
 
-func (s *Server) sendBlock(addr string, b *blockchain.Block) {
-	data := block{s.nodeAddress, b.Serialize()}
-	payload := s.gobEncode(data)
-	request := append(s.commandToBytes("block"), payload...)
+func (s *Server) sendHeaders(p *Peer, hs []*blockchain.BlockHeader) {
+	p.QueueMessage("headers", gobEncode(headers{hs}))
+}
 
-	s.sendData(addr, request)
+func (s *Server) sendCmpctBlock(p *Peer, b *blockchain.Block) {
+	p.QueueMessage("cmpctblock", gobEncode(newCompactBlock(b)))
 }
 
-func (s *Server) sendInv(address, kind string, items [][]byte) {
-	inventory := inv{s.nodeAddress, kind, items}
-	payload := s.gobEncode(inventory)
-	request := append(s.commandToBytes("inv"), payload...)
+func (s *Server) sendGetBlockTxn(p *Peer, blockHash []byte, indexes []int) {
+	p.QueueMessage("getblocktxn", gobEncode(getblocktxn{blockHash, indexes}))
+}
 
-	s.sendData(address, request)
+func (s *Server) sendBlockTxn(p *Peer, blockHash []byte, txs [][]byte) {
+	p.QueueMessage("blocktxn", gobEncode(blocktxn{blockHash, txs}))
 }
 
-func (s *Server) sendGetBlocks(address string) {
-	payload := s.gobEncode(getblocks{s.nodeAddress})
-	request := append(s.commandToBytes("getblocks"), payload...)
+func (s *Server) sendSendCmpct(p *Peer, announce bool) {
+	p.QueueMessage("sendcmpct", gobEncode(sendcmpct{announce}))
+}
 
-	s.sendData(address, request)
+func (s *Server) sendFilterLoad(p *Peer, filter *utils.BloomFilter) {
+	p.QueueMessage("filterload", gobEncode(filterload{filter}))
 }
 
-func (s *Server) sendGetData(address, kind string, id []byte) {
-	payload := s.gobEncode(getdata{s.nodeAddress, kind, id})
-	request := append(s.commandToBytes("getdata"), payload...)
+func (s *Server) sendMerkleBlock(p *Peer, b *blockchain.Block) {
+	tree, matched := b.FilterTransactions(p.filter)
 
-	s.sendData(address, request)
+	var txs [][]byte
+	for _, tx := range matched {
+		txs = append(txs, tx.Serialize())
+	}
+
+	p.QueueMessage("merkleblock", gobEncode(merkleblock{b.Header(), tree, txs}))
 }
 
-func (s *Server) SendTx(addr string, tnx *transaction.Transaction) {
-	data := tx{s.nodeAddress, tnx.Serialize()}
-	payload := s.gobEncode(data)
-	request := append(s.commandToBytes("tx"), payload...)
+func (s *Server) sendGetData(p *Peer, kind string, id []byte) {
+	p.QueueMessage("getdata", gobEncode(getdata{kind, id}))
+}
 
-	s.sendData(addr, request)
+func (s *Server) sendTx(p *Peer, tnx *transaction.Transaction) {
+	p.QueueMessage("tx", gobEncode(tx{tnx.Serialize()}))
 }
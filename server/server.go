@@ -3,73 +3,256 @@ package server
 import (
 	"bytes"
 	"encoding/gob"
-	"fmt"
+	"errors"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/config"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
 )
 
 const (
 	protocol      = "tcp"
-	nodeVersion   = 1
+	nodeVersion   = 6
 	commandLength = 12
+	// minProtocolVersion is the oldest version the node talks to, version 1 nodes don't acknowledge versions
+	minProtocolVersion = 2
+	userAgent          = "/learning-golang-blockchain:3/"
 )
 
+var errNoNodeReachable = errors.New("ERROR: No known node could be reached to send the transaction to")
+
 type Server struct {
 	nodeId          string
+	listenAddress   string
 	nodeAddress     string
 	miningAddress   string
-	knownNodes      []string
-	blocksInTransit [][]byte
+	seedNodes       []string
+	addrBook        *AddrBook
+	maxOutbound     int
+	maxInbound      int
+	banDuration     time.Duration
+	refreshRequests chan struct{}
+	sync            *blockSync
 	mempool         map[string]*transaction.Transaction
+	// orphanBlocks and orphanTxs hold the blocks and transactions waiting for their parents, by hex-encoded hash
+	orphanBlocks map[string]*orphanBlock
+	orphanTxs    map[string]*orphanTx
+	// orphanBlocksSize is the serialized size of the orphan blocks
+	orphanBlocksSize int
+	// partialBlocks holds the compact blocks waiting for their missing transactions, by hex-encoded hash
+	partialBlocks map[string]*partialBlock
+	// txRequests holds when the transactions requested from peers were requested, by hex-encoded ID
+	txRequests map[string]time.Time
+	peers      map[*Peer]bool
+	// bc is only set on a started node, a server used to send a transaction never syncs
+	bc *blockchain.Blockchain
+	// light is the sync of a light wallet, only set on a server that was not started while it syncs
+	light *lightSync
+	// watcher is told whenever the main chain of a started node changes, if any
+	watcher ChainWatcher
+	// mu serializes the handling of messages of every peer
+	mu sync.Mutex
 }
 
 // InitServer creates Server instance with empty miner address
-func InitServer(nodeId string) *Server {
+// The node listens on the configured address and tells peers its external one, the seed nodes are the first known nodes
+// The address book is only loaded once the node starts
+func InitServer(cfg *config.Config) *Server {
 	return &Server{
-		nodeId,
-		fmt.Sprintf("localhost:%s", nodeId),
-		"",
-		[]string{"localhost:3000"},
-		[][]byte{},
-		make(map[string]*transaction.Transaction),
+		nodeId:          cfg.NodeID,
+		listenAddress:   cfg.Listen,
+		nodeAddress:     cfg.ExternalAddress,
+		seedNodes:       append([]string{}, cfg.SeedNodes...),
+		addrBook:        &AddrBook{Addresses: make(map[string]*KnownAddress)},
+		maxOutbound:     cfg.MaxOutbound,
+		maxInbound:      cfg.MaxInbound,
+		banDuration:     cfg.BanDuration.Duration,
+		refreshRequests: make(chan struct{}, 1),
+		sync:            newBlockSync(),
+		mempool:         make(map[string]*transaction.Transaction),
+		orphanBlocks:    make(map[string]*orphanBlock),
+		orphanTxs:       make(map[string]*orphanTx),
+		partialBlocks:   make(map[string]*partialBlock),
+		txRequests:      make(map[string]time.Time),
+		peers:           make(map[*Peer]bool),
 	}
 }
 
+// KnownNodes returns the seed nodes, followed by the other addresses of the address book that are not banned
 func (s *Server) KnownNodes() []string {
-	return s.knownNodes
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := append([]string{}, s.seedNodes...)
+
+	for _, ka := range s.addrBook.List() {
+		if !s.addrBook.IsBanned(ka.Addr) && !s.isSeedNode(ka.Addr) {
+			nodes = append(nodes, ka.Addr)
+		}
+	}
+
+	return nodes
+}
+
+// Watch makes the watcher follow the main chain once the node is started
+func (s *Server) Watch(watcher ChainWatcher) {
+	s.watcher = watcher
 }
 
 // Start starts a node
+// It keeps up to maxOutbound connections to the nodes of its address book and accepts up to maxInbound ones
 func (s *Server) Start(minerAddress string) {
 	s.miningAddress = minerAddress
 
-	ln, err := net.Listen(protocol, s.nodeAddress)
+	ln, err := net.Listen(protocol, s.listenAddress)
 	if err != nil {
 		log.Panic(err)
 	}
 
 	defer ln.Close()
 
-	bc := blockchain.NewBlockchain(s.nodeId)
+	s.bc = blockchain.NewBlockchain(s.nodeId)
 
-	if s.nodeAddress != s.knownNodes[0] {
-		s.sendVersion(s.knownNodes[0], bc)
+	s.addrBook, err = NewAddrBook(s.nodeId)
+	if err != nil {
+		log.Panic(err)
 	}
 
+	for _, node := range s.seedNodes {
+		if node != s.nodeAddress {
+			s.addrBook.Add(node, SourceSeed)
+		}
+	}
+
+	go s.maintainPeers()
+	go s.trickle()
+	go s.watchDownloads()
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			log.Panic(err)
 		}
 
-		go s.handleConnection(conn, bc)
+		p := newPeer(conn, conn.RemoteAddr().String(), true)
+
+		s.mu.Lock()
+		switch {
+		case s.addrBook.IsBanned(p.banKey()):
+			logger.Infof("Refused %s, its host is banned", conn.RemoteAddr())
+			conn.Close()
+		case s.peerCount(true) >= s.maxInbound:
+			logger.Debugf("Refused %s, %d inbound peers already", conn.RemoteAddr(), s.maxInbound)
+			conn.Close()
+		default:
+			s.addPeer(p)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// SendTx hands a transaction to the first known node that completes a handshake, which relays it to its peers
+// The nodes of the address book of a node that ran before are tried after the seed nodes
+func (s *Server) SendTx(tnx *transaction.Transaction) error {
+	s.loadAddrBook()
+
+	for _, addr := range s.KnownNodes() {
+		if addr != s.nodeAddress && s.sendTxTo(addr, tnx) {
+			return nil
+		}
+	}
+
+	return errNoNodeReachable
+}
+
+// loadAddrBook loads the address book of the node, if it ran before, for a server that was not started to find nodes
+func (s *Server) loadAddrBook() {
+	book, err := NewAddrBook(s.nodeId)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.addrBook = book
+	s.mu.Unlock()
+}
+
+// sendTxTo sends a transaction to the node at the address over a connection closed once it is sent
+func (s *Server) sendTxTo(addr string, tnx *transaction.Transaction) bool {
+	p, err := s.dial(addr)
+	if err != nil {
+		return false
+	}
+
+	select {
+	case <-p.handshake:
+	case <-p.quit:
+		return false
+	}
+
+	s.sendTx(p, tnx)
+	p.closeAfterQueued()
+	<-p.done
+
+	return true
+}
+
+// addPeer starts the loops of a new peer. The caller holds s.mu
+func (s *Server) addPeer(p *Peer) {
+	s.peers[p] = true
+	p.start(s.handleMessage, s.removePeer)
+
+	logger.Debugf("Connected to %s, %d peers now", p.addr, len(s.peers))
+}
+
+func (s *Server) removePeer(p *Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.peers, p)
+	logger.Debugf("Disconnected from %s, %d peers now", p.addr, len(s.peers))
+
+	if s.bc != nil {
+		s.dropDownloads(p)
+	}
+}
+
+// services returns the capabilities the node advertises, a server that was not started serves nothing
+func (s *Server) services() ServiceFlag {
+	if s.bc == nil {
+		return 0
+	}
+
+	return SFNodeNetwork | SFNodeBloom
+}
+
+// outboundPeerWithNonce returns the outbound peer the node sent a version with the nonce to, if any
+// Receiving that nonce back means the node connected to itself
+func (s *Server) outboundPeerWithNonce(nonce uint64) *Peer {
+	for p := range s.peers {
+		if !p.inbound && p.nonce == nonce {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// bestHeight returns the height of the chain, -1 on a server that was not started
+func (s *Server) bestHeight() int {
+	if s.bc == nil {
+		return -1
 	}
+
+	return s.bc.GetBestHeight()
 }
 
-func (s *Server) gobEncode(data interface{}) []byte {
+func gobEncode(data interface{}) []byte {
 	var buff bytes.Buffer
 
 	enc := gob.NewEncoder(&buff)
@@ -81,8 +264,8 @@ func (s *Server) gobEncode(data interface{}) []byte {
 	return buff.Bytes()
 }
 
-func (s *Server) nodeIsKnown(addr string) bool {
-	for _, node := range s.knownNodes {
+func (s *Server) isSeedNode(addr string) bool {
+	for _, node := range s.seedNodes {
 		if node == addr {
 			return true
 		}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceFlag is a capability a node advertises in its version message
+type ServiceFlag uint64
+
+const (
+	// SFNodeNetwork is a full node serving every block of the chain
+	SFNodeNetwork ServiceFlag = 1 << iota
+	// SFNodeBloom serves filtered blocks to light clients matching a bloom filter
+	SFNodeBloom
+	// SFNodePruned only serves the recent blocks it kept
+	SFNodePruned
+)
+
+var serviceFlagNames = []struct {
+	flag ServiceFlag
+	name string
+}{
+	{SFNodeNetwork, "NETWORK"},
+	{SFNodeBloom, "BLOOM"},
+	{SFNodePruned, "PRUNED"},
+}
+
+// Has tells whether every capability of the flag is advertised
+func (f ServiceFlag) Has(flag ServiceFlag) bool {
+	return f&flag == flag
+}
+
+// String lists the names of the capabilities, unknown ones as a hex value
+func (f ServiceFlag) String() string {
+	var names []string
+
+	for _, service := range serviceFlagNames {
+		if f.Has(service.flag) {
+			names = append(names, service.name)
+			f &^= service.flag
+		}
+	}
+
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint64(f)))
+	}
+
+	if len(names) == 0 {
+		return "NONE"
+	}
+
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/logger"
+)
+
+const (
+	// headersVersion is the protocol version that introduced getheaders and headers messages
+	headersVersion = 4
+	// maxHeadersPerMessage is the most headers a headers message may carry, a full one means the sender has more
+	maxHeadersPerMessage = 2000
+	// maxBlocksPerInv is the most blocks a getblocks is answered with, a full inventory means the sender has more
+	maxBlocksPerInv = 500
+	// Blocks are downloaded from up to blockDownloadWindow blocks past the tip, at most maxBlocksInFlightPerPeer from each peer
+	blockDownloadWindow      = 128
+	maxBlocksInFlightPerPeer = 16
+	// A peer not answering a request for blocks or headers within these is disconnected and the request goes to another one
+	blockDownloadTimeout  = 30 * time.Second
+	headersTimeout        = time.Minute
+	downloadCheckInterval = 5 * time.Second
+	// maxHeaderTimeDrift is how far ahead of the clock of the node a header may be
+	maxHeaderTimeDrift = 2 * time.Hour
+)
+
+var (
+	errUnknownHeaderParent = errors.New("ERROR: The parent of the header is unknown")
+	errHeaderFromFuture    = errors.New("ERROR: The header is too far in the future")
+)
+
+// ChainWatcher follows the main chain of a node, such as a wallet keeping its history up to date
+type ChainWatcher interface {
+	// ChainChanged is called once blocks were connected or mined, including when they made another branch the main chain
+	ChainChanged(bc *blockchain.Blockchain) error
+}
+
+// blockSync is the state of the headers-first sync
+// The headers come from a single peer first, then the blocks are downloaded from every peer having them and connected in height order
+type blockSync struct {
+	// headers holds the valid headers of blocks the chain does not have yet, by hex-encoded hash
+	headers map[string]*blockchain.BlockHeader
+	// headerTip is the hash of the highest header
+	headerTip []byte
+	// syncPeer is the peer the headers are requested from, if any
+	syncPeer           *Peer
+	headersRequestedAt time.Time
+	// inFlight holds the blocks requested from peers, received the blocks waiting for their parent to be connected
+	inFlight map[string]*blockRequest
+	received map[string]*receivedBlock
+}
+
+// blockRequest is a block requested from a peer
+type blockRequest struct {
+	peer        *Peer
+	requestedAt time.Time
+}
+
+// receivedBlock is a block waiting to be connected with the peer it came from, which is blamed when it is invalid
+type receivedBlock struct {
+	block *blockchain.Block
+	peer  *Peer
+}
+
+func newBlockSync() *blockSync {
+	return &blockSync{
+		headers:  make(map[string]*blockchain.BlockHeader),
+		inFlight: make(map[string]*blockRequest),
+		received: make(map[string]*receivedBlock),
+	}
+}
+
+// startSync requests headers from the peer unless another peer is already being synced from. The caller holds s.mu
+func (s *Server) startSync(p *Peer) {
+	if s.sync.syncPeer != nil {
+		return
+	}
+
+	logger.Infof("Syncing headers from %s, at height %d", p.addr, p.bestHeight)
+
+	s.sync.syncPeer = p
+	s.sendGetHeaders(p)
+}
+
+// requestBlocks asks the peer for the blocks the node misses, with getheaders when the peer serves headers and getblocks otherwise
+// The caller holds s.mu
+func (s *Server) requestBlocks(p *Peer) {
+	if p.version >= headersVersion {
+		s.sendGetHeaders(p)
+	} else {
+		s.sendGetBlocks(p)
+	}
+}
+
+// resumeSync picks another peer to sync the headers from, one whose chain is longer than the known headers. The caller holds s.mu
+func (s *Server) resumeSync() {
+	tipHeight := s.headerTipHeight()
+
+	for p := range s.peers {
+		if s.canDownloadFrom(p) && p.version >= headersVersion && p.bestHeight > tipHeight {
+			s.startSync(p)
+			return
+		}
+	}
+}
+
+// headerTipHeight returns the height of the highest header, the one of the chain when all its blocks are connected. The caller holds s.mu
+func (s *Server) headerTipHeight() int {
+	if h := s.sync.headers[hex.EncodeToString(s.sync.headerTip)]; h != nil {
+		return h.Height()
+	}
+
+	return s.bc.GetBestHeight()
+}
+
+// pendingHeaders returns the headers from the chain to the highest header, lowest first. The caller holds s.mu
+func (s *Server) pendingHeaders() []*blockchain.BlockHeader {
+	var pending []*blockchain.BlockHeader
+
+	for h := s.sync.headers[hex.EncodeToString(s.sync.headerTip)]; h != nil; h = s.sync.headers[hex.EncodeToString(h.PrevBlockHash())] {
+		pending = append(pending, h)
+	}
+
+	slices.Reverse(pending)
+
+	return pending
+}
+
+// locator returns the block locator of the highest header, the chain stands in for the heights below the pending headers
+// A light wallet has its own header chain. The caller holds s.mu
+func (s *Server) locator() [][]byte {
+	if s.light != nil {
+		return s.light.wallet.Locator()
+	}
+
+	pending := s.pendingHeaders()
+	if len(pending) == 0 {
+		return s.bc.BlockLocator()
+	}
+
+	base := pending[0].Height() - 1
+
+	var locator [][]byte
+	for _, height := range blockchain.LocatorHeights(base + len(pending)) {
+		if height > base {
+			locator = append(locator, pending[height-base-1].Hash())
+		} else if hash, ok := s.bc.HashAtHeight(height); ok {
+			locator = append(locator, hash)
+		}
+	}
+
+	return locator
+}
+
+// acceptHeader checks that the header follows a known block or header with a valid proof of work and keeps it. The caller holds s.mu
+func (s *Server) acceptHeader(h *blockchain.BlockHeader) error {
+	key := hex.EncodeToString(h.Hash())
+	if s.sync.headers[key] != nil {
+		return nil
+	}
+
+	if _, err := s.bc.GetBlock(h.Hash()); err == nil {
+		return nil
+	}
+
+	var prevHeight int
+	if prev := s.sync.headers[hex.EncodeToString(h.PrevBlockHash())]; prev != nil {
+		prevHeight = prev.Height()
+	} else if prev, err := s.bc.GetBlock(h.PrevBlockHash()); err == nil {
+		prevHeight = prev.Height()
+	} else {
+		return errUnknownHeaderParent
+	}
+
+	if h.Height() != prevHeight+1 {
+		return fmt.Errorf("header %x claims height %d after a block at height %d", h.Hash(), h.Height(), prevHeight)
+	}
+
+	if !blockchain.NewHeaderProofOfWork(h).Validate() {
+		return fmt.Errorf("header %x has an invalid proof of work", h.Hash())
+	}
+
+	if time.Unix(h.Timestamp(), 0).After(time.Now().Add(maxHeaderTimeDrift)) {
+		return errHeaderFromFuture
+	}
+
+	if h.Height() > s.headerTipHeight() {
+		s.sync.headerTip = h.Hash()
+	}
+
+	s.sync.headers[key] = h
+
+	return nil
+}
+
+// canDownloadFrom tells whether blocks and headers can be requested from the peer. The caller holds s.mu
+func (s *Server) canDownloadFrom(p *Peer) bool {
+	return p.state == peerEstablished && p.services.Has(SFNodeNetwork)
+}
+
+// fillDownloads requests the missing blocks of the download window from the peers having them
+// Each block goes to the peer with the fewest requests in flight, so the blocks come from several peers at once
+// The block following the tip of a synced chain is requested as a compact block from peers speaking them. The caller holds s.mu
+func (s *Server) fillDownloads() {
+	inFlight := make(map[*Peer]int)
+	for _, r := range s.sync.inFlight {
+		inFlight[r.peer]++
+	}
+
+	pending := s.pendingHeaders()
+
+	for _, h := range pending[:min(blockDownloadWindow, len(pending))] {
+		key := hex.EncodeToString(h.Hash())
+		if s.sync.inFlight[key] != nil || s.sync.received[key] != nil {
+			continue
+		}
+
+		var best *Peer
+		for p := range s.peers {
+			if !s.canDownloadFrom(p) || p.bestHeight < h.Height() || inFlight[p] >= maxBlocksInFlightPerPeer {
+				continue
+			}
+
+			if best == nil || inFlight[p] < inFlight[best] {
+				best = p
+			}
+		}
+
+		if best == nil {
+			continue
+		}
+
+		kind := "block"
+		if len(pending) == 1 && best.version >= compactBlocksVersion {
+			kind = "cmpctblock"
+		}
+
+		inFlight[best]++
+		s.sync.inFlight[key] = &blockRequest{best, time.Now()}
+		s.sendGetData(best, kind, h.Hash())
+	}
+}
+
+// receiveBlock takes a block, then the orphan blocks it is the parent of. The caller holds s.mu
+func (s *Server) receiveBlock(p *Peer, b *blockchain.Block) {
+	s.acceptBlock(p, b)
+	s.processOrphanBlocks()
+}
+
+// acceptBlock keeps a block until the blocks before it are connected, then connects it
+// A block nobody requested is taken when it follows a known block or header, otherwise it becomes an orphan
+// Receiving the last block of a full inventory asks the peer for the next ones. The caller holds s.mu
+func (s *Server) acceptBlock(p *Peer, b *blockchain.Block) {
+	key := hex.EncodeToString(b.Hash())
+	delete(s.sync.inFlight, key)
+
+	if _, err := s.bc.GetBlock(b.Hash()); err == nil {
+		return
+	}
+
+	if s.sync.headers[key] == nil {
+		err := s.acceptHeader(b.Header())
+		if err == errUnknownHeaderParent {
+			s.addOrphanBlock(p, b)
+			return
+		}
+
+		if err != nil {
+			s.misbehave(p, scoreInvalidBlock, err.Error())
+			return
+		}
+
+		p.bestHeight = max(p.bestHeight, b.Height())
+	}
+
+	s.sync.received[key] = &receivedBlock{b, p}
+
+	s.connectBlocks()
+	s.fillDownloads()
+
+	if p.continueHash != nil && bytes.Equal(b.Hash(), p.continueHash) {
+		p.continueHash = nil
+		s.sendGetBlocks(p)
+	}
+}
+
+// connectBlocks connects the received blocks following the chain, strictly in height order
+// Once the chain caught up with the headers, its tip is announced to the peers. The caller holds s.mu
+func (s *Server) connectBlocks() {
+	pending := s.pendingHeaders()
+	connected := 0
+
+	for i, h := range pending {
+		key := hex.EncodeToString(h.Hash())
+
+		r := s.sync.received[key]
+		if r == nil {
+			break
+		}
+
+		err := s.connectBlock(r.block)
+		if err != nil {
+			s.misbehave(r.peer, scoreInvalidBlock, fmt.Sprintf("block %x is invalid, %s", r.block.Hash(), err))
+			s.dropHeaders(pending[i:])
+			break
+		}
+
+		delete(s.sync.received, key)
+		delete(s.sync.headers, key)
+		connected++
+	}
+
+	if connected == 0 {
+		return
+	}
+
+	s.notifyChainChanged()
+
+	// Headers and blocks of branches the chain went past are forgotten
+	onPath := make(map[string]bool)
+	for _, h := range pending[connected:] {
+		onPath[hex.EncodeToString(h.Hash())] = true
+	}
+
+	bestHeight := s.bc.GetBestHeight()
+
+	for key, h := range s.sync.headers {
+		if h.Height() <= bestHeight && !onPath[key] {
+			delete(s.sync.headers, key)
+			delete(s.sync.received, key)
+		}
+	}
+
+	if connected == len(pending) {
+		logger.Debugf("Synced up to height %d", bestHeight)
+		s.relayBlock(nil, s.bc.Tip())
+	}
+}
+
+// connectBlock checks the transactions of the block, adds it to the chain and updates the UTXO set, the transactions
+// of the block leave the mempool and the orphan transactions spending from them are taken
+// The UTXO set is rebuilt when the block made another branch the main chain. The caller holds s.mu
+func (s *Server) connectBlock(b *blockchain.Block) error {
+	prevTip := s.bc.Tip()
+	UTXOSet := chainstate.NewUTXOSet(s.bc)
+
+	// The UTXO set is the one of the tip. A block making its branch the main chain has the whole branch checked from
+	// where it leaves the main chain, one staying on the side only gets the checks not needing unspent outputs
+	var err error
+	switch {
+	case bytes.Equal(b.PrevBlockHash(), prevTip):
+		err = UTXOSet.CheckBlock(b)
+	case b.Height() > s.bc.GetBestHeight():
+		var branch []*blockchain.Block
+		branch, err = s.bc.Branch(b)
+		if err == nil {
+			err = UTXOSet.CheckBranch(branch)
+		}
+	default:
+		err = b.CheckTransactions()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = s.bc.AddBlock(b)
+	if err != nil {
+		logger.Warnf("Can't add block %x: %s", b.Hash(), err)
+		return nil
+	}
+
+	switch {
+	case !bytes.Equal(s.bc.Tip(), b.Hash()):
+	case bytes.Equal(b.PrevBlockHash(), prevTip):
+		UTXOSet.Update(b)
+	default:
+		UTXOSet.Reindex()
+	}
+
+	for _, tx := range b.Transactions() {
+		delete(s.mempool, hex.EncodeToString(tx.ID()))
+	}
+
+	logger.Infof("Added block %x at height %d", b.Hash(), b.Height())
+
+	for _, tx := range b.Transactions() {
+		s.processOrphanTxs(tx.ID())
+	}
+
+	return nil
+}
+
+// dropHeaders forgets the headers from an invalid block on, with their blocks. The caller holds s.mu
+func (s *Server) dropHeaders(headers []*blockchain.BlockHeader) {
+	for _, h := range headers {
+		key := hex.EncodeToString(h.Hash())
+		delete(s.sync.headers, key)
+		delete(s.sync.received, key)
+		delete(s.sync.inFlight, key)
+	}
+
+	s.sync.headerTip = nil
+	if s.sync.headers[hex.EncodeToString(headers[0].PrevBlockHash())] != nil {
+		s.sync.headerTip = headers[0].PrevBlockHash()
+	}
+}
+
+// notifyChainChanged tells the watcher the main chain changed. The caller holds s.mu
+func (s *Server) notifyChainChanged() {
+	if s.watcher == nil {
+		return
+	}
+
+	err := s.watcher.ChainChanged(s.bc)
+	if err != nil {
+		logger.Warnf("Can't follow the chain: %s", err)
+	}
+}
+
+// dropDownloads hands the requests of a peer that went away to the other peers and syncs the headers from another one
+// if it was the sync peer. The caller holds s.mu
+func (s *Server) dropDownloads(p *Peer) {
+	for key, r := range s.sync.inFlight {
+		if r.peer == p {
+			delete(s.sync.inFlight, key)
+		}
+	}
+
+	for key, partial := range s.partialBlocks {
+		if partial.from == p {
+			delete(s.partialBlocks, key)
+		}
+	}
+
+	if s.sync.syncPeer == p {
+		s.sync.syncPeer = nil
+		s.resumeSync()
+	}
+
+	s.fillDownloads()
+}
+
+// watchDownloads disconnects the peers that leave requests for blocks or headers unanswered too long, until the node stops
+// Their requests go to the other peers then
+func (s *Server) watchDownloads() {
+	ticker := time.NewTicker(downloadCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+
+		for key, r := range s.sync.inFlight {
+			if time.Since(r.requestedAt) > blockDownloadTimeout {
+				logger.Warnf("Peer %s did not send block %s in time, disconnecting", r.peer.addr, key)
+				r.peer.disconnect()
+			}
+		}
+
+		if p := s.sync.syncPeer; p != nil && time.Since(s.sync.headersRequestedAt) > headersTimeout {
+			logger.Warnf("Peer %s did not send headers in time, disconnecting", p.addr)
+			p.disconnect()
+		}
+
+		s.mu.Unlock()
+	}
+}
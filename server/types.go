@@ -1,37 +1,117 @@
 package server
 
+import (
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
 type addr struct {
-	addrList []string
+	AddrList []netAddress
+}
+
+// netAddress is the address of a node with the services it offers, Timestamp is when it was last known to be up
+type netAddress struct {
+	Addr      string
+	Services  ServiceFlag
+	Timestamp int64
 }
 
 type block struct {
-	addrFrom string
-	block    []byte
+	Block []byte
 }
 
+// getblocks asks for the inventory of the blocks following the first block of the locator the peer has, up to the one with StopHash
+// Peers older than headersVersion send it without a payload
 type getblocks struct {
-	addrFrom string
+	Locator  [][]byte
+	StopHash []byte
+}
+
+// cmpctblock is a block made of its header, the short IDs of the transactions the peer likely has and the other transactions
+type cmpctblock struct {
+	Header    *blockchain.BlockHeader
+	Nonce     uint64
+	ShortIDs  []uint64
+	Prefilled []prefilledTx
+}
+
+// prefilledTx is a transaction sent in full in a compact block, Index is its position in the block
+type prefilledTx struct {
+	Index       int
+	Transaction []byte
+}
+
+// getblocktxn asks for the transactions at the indexes of a block, the ones missing to rebuild it from its compact block
+type getblocktxn struct {
+	BlockHash []byte
+	Indexes   []int
+}
+
+type blocktxn struct {
+	BlockHash    []byte
+	Transactions [][]byte
+}
+
+// sendcmpct asks the peer to push new blocks as compact blocks when Announce is set, and to announce them with inv otherwise
+type sendcmpct struct {
+	Announce bool
+}
+
+// filterload asks the peer to only relay the transactions matching the filter, and to serve filtered blocks
+type filterload struct {
+	Filter *utils.BloomFilter
+}
+
+// filteradd adds the data to the filter the peer loaded
+type filteradd struct {
+	Data []byte
 }
 
 type getdata struct {
-	addrFrom string
-	kind     string
-	id       []byte
+	Kind string
+	ID   []byte
+}
+
+// getheaders asks for the headers following the first block of the locator the peer has, up to the one with StopHash
+// A nil StopHash asks for as many as fit in a message
+type getheaders struct {
+	Locator  [][]byte
+	StopHash []byte
+}
+
+type headers struct {
+	Headers []*blockchain.BlockHeader
 }
 
 type inv struct {
-	addrFrom string
-	kind     string
-	items    [][]byte
+	Kind  string
+	Items [][]byte
+}
+
+// merkleblock is a filtered block: its header, the transactions matching the filter and the partial Merkle tree proving them
+type merkleblock struct {
+	Header       *blockchain.BlockHeader
+	Tree         *utils.PartialMerkleTree
+	Transactions [][]byte
+}
+
+type ping struct {
+	Nonce uint64
 }
 
 type tx struct {
-	addFrom     string
-	transaction []byte
+	Transaction []byte
 }
 
+// verzion opens the handshake, AddrFrom is the address the node listens on
+// Nonce is random for every connection, a node receiving its own nonce back connected to itself
 type verzion struct {
-	version    int
-	bestHeight int
-	addrFrom   string
+	Version    int
+	Services   ServiceFlag
+	Timestamp  int64
+	AddrRecv   string
+	AddrFrom   string
+	Nonce      uint64
+	UserAgent  string
+	BestHeight int
 }
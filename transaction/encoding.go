@@ -0,0 +1,15 @@
+package transaction
+
+import "encoding/binary"
+
+// appendInt appends the big-endian encoding of n
+func appendInt(buf []byte, n int) []byte {
+	return binary.BigEndian.AppendUint64(buf, uint64(n))
+}
+
+// appendBytes appends data prefixed with its length, so consecutive fields can't run into each other
+func appendBytes(buf, data []byte) []byte {
+	buf = appendInt(buf, len(data))
+
+	return append(buf, data...)
+}
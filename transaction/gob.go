@@ -0,0 +1,18 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+func gobEncode(data interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(data)
+
+	return buff.Bytes(), err
+}
+
+func gobDecode(data []byte, target interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+}
@@ -3,18 +3,19 @@ package transaction
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"strings"
-)
 
-const subsidy = 10
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
 
 type Transaction struct {
 	id   []byte
@@ -22,8 +23,16 @@ type Transaction struct {
 	vout []TXOutput
 }
 
+// transactionData is the serialized form of a Transaction
+type transactionData struct {
+	ID   []byte
+	Vin  []TXInput
+	Vout []TXOutput
+}
+
 // NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+// It pays the subsidy the active network sets for the block at the height
+func NewCoinbaseTX(to, data string, height int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -35,7 +44,7 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	}
 
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(chaincfg.ActiveParams().Subsidy(height), to)
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
 	tx.id = tx.Hash()
 
@@ -52,10 +61,7 @@ func BuildTransaction(inputs []TXInput, outputs []TXOutput) *Transaction {
 
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -63,6 +69,30 @@ func DeserializeTransaction(data []byte) Transaction {
 	return transaction
 }
 
+// DecodeTransaction deserializes a transaction that may be malformed, such as one received from a peer
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&transaction)
+
+	return transaction, err
+}
+
+// GobEncode encodes the Transaction, gob can't reach its unexported fields
+func (t *Transaction) GobEncode() ([]byte, error) {
+	return gobEncode(transactionData{t.id, t.vin, t.vout})
+}
+
+// GobDecode decodes a Transaction encoded by GobEncode
+func (t *Transaction) GobDecode(data []byte) error {
+	var decoded transactionData
+
+	err := gobDecode(data, &decoded)
+	t.id, t.vin, t.vout = decoded.ID, decoded.Vin, decoded.Vout
+
+	return err
+}
+
 func (t *Transaction) ID() []byte {
 	return t.id
 }
@@ -80,6 +110,32 @@ func (t *Transaction) IsCoinbase() bool {
 	return len(t.vin) == 1 && len(t.vin[0].txId) == 0 && t.vin[0].vout == -1
 }
 
+// MatchesFilter tells whether the bloom filter may want the transaction
+// It does when it has the ID of the transaction, or the hash of the public key of an output or of a signed input
+func (t *Transaction) MatchesFilter(filter *utils.BloomFilter) bool {
+	if filter.Contains(t.id) {
+		return true
+	}
+
+	for _, out := range t.vout {
+		if filter.Contains(out.pubkeyHash) {
+			return true
+		}
+	}
+
+	if t.IsCoinbase() {
+		return false
+	}
+
+	for _, in := range t.vin {
+		if filter.Contains(utils.HashPubKey(in.pubkey)) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Serialize returns a serialized Transaction
 func (t *Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
@@ -93,6 +149,28 @@ func (t *Transaction) Serialize() []byte {
 	return encoded.Bytes()
 }
 
+// Bytes returns the encoding the hash of the Transaction and the Merkle tree of its block are computed from
+// Unlike the gob encoding of Serialize, it only depends on the content of the transaction
+func (t *Transaction) Bytes() []byte {
+	buf := appendBytes(nil, t.id)
+
+	buf = appendInt(buf, len(t.vin))
+	for _, vin := range t.vin {
+		buf = appendBytes(buf, vin.txId)
+		buf = appendInt(buf, vin.vout)
+		buf = appendBytes(buf, vin.signature)
+		buf = appendBytes(buf, vin.pubkey)
+	}
+
+	buf = appendInt(buf, len(t.vout))
+	for _, vout := range t.vout {
+		buf = appendInt(buf, vout.value)
+		buf = appendBytes(buf, vout.pubkeyHash)
+	}
+
+	return buf
+}
+
 // Hash returns the hash of the Transaction
 func (t *Transaction) Hash() []byte {
 	var hash [32]byte
@@ -100,14 +178,81 @@ func (t *Transaction) Hash() []byte {
 	txCopy := *t
 	txCopy.id = []byte{}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(txCopy.Bytes())
 	return hash[:]
 }
 
+// UnsignedHash returns the hash of the Transaction without the signatures of its inputs
+// The ID is computed before the inputs are signed, so a signed transaction is checked against this hash
+func (t *Transaction) UnsignedHash() []byte {
+	txCopy := *t
+	txCopy.vin = make([]TXInput, len(t.vin))
+
+	for inId, vin := range t.vin {
+		vin.signature = nil
+		txCopy.vin[inId] = vin
+	}
+
+	return txCopy.Hash()
+}
+
 // Sign signs each input of a Transaction
 func (t *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+	_, err := t.sign(func(pubKeyHash []byte) (ecdsa.PrivateKey, bool) {
+		return privateKey, true
+	}, prevTxs, false)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// SignWithKeys signs each input with the key owning the output it spends
+// The keys are indexed by the hex-encoded public key hash they unlock
+func (t *Transaction) SignWithKeys(privateKeys map[string]ecdsa.PrivateKey, prevTxs map[string]Transaction) {
+	_, err := t.sign(keysByPubKeyHash(privateKeys), prevTxs, false)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// SignPartial signs the inputs spending outputs one of the keys unlocks and returns how many it signed
+// The other inputs are left for another signer, so the transaction can be signed in several places
+func (t *Transaction) SignPartial(privateKeys map[string]ecdsa.PrivateKey, prevTxs map[string]Transaction) (int, error) {
+	return t.sign(keysByPubKeyHash(privateKeys), prevTxs, true)
+}
+
+// IsSigned tells whether every input carries a signature
+func (t *Transaction) IsSigned() bool {
+	for _, vin := range t.vin {
+		if vin.signature == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Combine copies the signatures of the other copy of the transaction into the inputs still unsigned
+func (t *Transaction) Combine(other Transaction) error {
+	if !bytes.Equal(t.id, other.id) || len(t.vin) != len(other.vin) {
+		return errors.New("ERROR: Transactions to combine are not the same")
+	}
+
+	for inId, vin := range other.vin {
+		if t.vin[inId].signature == nil && vin.signature != nil {
+			t.vin[inId].signature = vin.signature
+			t.vin[inId].pubkey = vin.pubkey
+		}
+	}
+
+	return nil
+}
+
+// sign signs every input keyFor has a key for and returns how many it signed
+// Unless partial is set a missing key is an error
+func (t *Transaction) sign(keyFor func(pubKeyHash []byte) (ecdsa.PrivateKey, bool), prevTxs map[string]Transaction, partial bool) (int, error) {
 	if t.IsCoinbase() {
-		return
+		return 0, nil
 	}
 
 	for _, vin := range t.Vin() {
@@ -116,12 +261,29 @@ func (t *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Trans
 		}
 	}
 
+	err := t.fillPubKeys(keyFor, prevTxs)
+	if err != nil {
+		return 0, err
+	}
+
 	txCopy := t.TrimmedCopy()
+	signed := 0
 
 	for inId, vin := range txCopy.Vin() {
 		prevTx := prevTxs[hex.EncodeToString(vin.TxId())]
+		prevPubKeyHash := prevTx.Vout()[vin.Vout()].PubKeyHash()
+
+		privateKey, ok := keyFor(prevPubKeyHash)
+		if !ok {
+			if partial {
+				continue
+			}
+
+			log.Panicf("ERROR: No private key for input %d", inId)
+		}
+
 		txCopy.Vin()[inId].signature = nil
-		txCopy.Vin()[inId].pubkey = prevTx.Vout()[vin.Vout()].PubKeyHash()
+		txCopy.Vin()[inId].pubkey = prevPubKeyHash
 
 		dataToSign := fmt.Sprintf("%x\n", txCopy)
 
@@ -134,6 +296,66 @@ func (t *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Trans
 
 		t.Vin()[inId].signature = signature
 		txCopy.Vin()[inId].pubkey = nil
+		signed++
+	}
+
+	return signed, nil
+}
+
+// fillPubKeys gives the inputs built without a public key, such as those of a watch-only address, the one of the key
+// keyFor holds for them and computes the ID again
+// The signatures cover the ID, so the public keys must be filled in before any input is signed
+func (t *Transaction) fillPubKeys(keyFor func(pubKeyHash []byte) (ecdsa.PrivateKey, bool), prevTxs map[string]Transaction) error {
+	pubKeys := make(map[int][]byte)
+
+	for inId, vin := range t.vin {
+		if vin.pubkey != nil {
+			continue
+		}
+
+		prevTx := prevTxs[hex.EncodeToString(vin.TxId())]
+		prevPubKeyHash := prevTx.Vout()[vin.Vout()].PubKeyHash()
+
+		privateKey, ok := keyFor(prevPubKeyHash)
+		if ok {
+			pubKeys[inId] = pubKeyFor(privateKey.PublicKey, prevPubKeyHash)
+		}
+	}
+
+	if len(pubKeys) == 0 {
+		return nil
+	}
+
+	for inId, vin := range t.vin {
+		if vin.signature != nil {
+			return fmt.Errorf("ERROR: Input %d is already signed, the public keys of the other inputs can't change the ID any more", inId)
+		}
+	}
+
+	for inId, pubKey := range pubKeys {
+		t.vin[inId].pubkey = pubKey
+	}
+
+	t.id = t.Hash()
+
+	return nil
+}
+
+// pubKeyFor returns the encoding of the public key the output was locked with, compressed or raw
+func pubKeyFor(publicKey ecdsa.PublicKey, pubKeyHash []byte) []byte {
+	compressed := utils.CompressPubKey(publicKey)
+	if bytes.Equal(utils.HashPubKey(compressed), pubKeyHash) {
+		return compressed
+	}
+
+	return utils.PublicKeyBytes(publicKey)
+}
+
+// keysByPubKeyHash looks keys up by the hex-encoded public key hash they unlock
+func keysByPubKeyHash(privateKeys map[string]ecdsa.PrivateKey) func(pubKeyHash []byte) (ecdsa.PrivateKey, bool) {
+	return func(pubKeyHash []byte) (ecdsa.PrivateKey, bool) {
+		privateKey, ok := privateKeys[hex.EncodeToString(pubKeyHash)]
+		return privateKey, ok
 	}
 }
 
@@ -183,18 +405,29 @@ func (t *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	}
 
 	for _, vin := range t.vin {
-		if prevTxs[hex.EncodeToString(vin.txId)].id == nil {
+		prevTx := prevTxs[hex.EncodeToString(vin.txId)]
+		if prevTx.id == nil {
 			log.Panic("ERROR: Previous transaction is not correct")
 		}
+
+		// The output spent comes from a peer, it may not exist
+		if vin.vout < 0 || vin.vout >= len(prevTx.vout) {
+			return false
+		}
 	}
 
 	txCopy := t.TrimmedCopy()
-	curve := elliptic.P256()
 
 	for inId, vin := range t.vin {
 		prevTx := prevTxs[hex.EncodeToString(vin.TxId())]
+		prevPubKeyHash := prevTx.Vout()[vin.Vout()].PubKeyHash()
 		txCopy.Vin()[inId].signature = nil
-		txCopy.Vin()[inId].pubkey = prevTx.Vout()[vin.Vout()].PubKeyHash()
+		txCopy.Vin()[inId].pubkey = prevPubKeyHash
+
+		// The key has to be the one the output was locked with, in the same encoding
+		if !bytes.Equal(utils.HashPubKey(vin.PubKey()), prevPubKeyHash) {
+			return false
+		}
 
 		r := big.Int{}
 		s := big.Int{}
@@ -202,16 +435,14 @@ func (t *Transaction) Verify(prevTxs map[string]Transaction) bool {
 		r.SetBytes(vin.Signature()[:(sigLen / 2)])
 		s.SetBytes(vin.Signature()[(sigLen / 2):])
 
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey())
-		x.SetBytes(vin.PubKey()[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey()[(keyLen / 2):])
+		pubKey, err := utils.ParsePubKey(vin.PubKey())
+		if err != nil {
+			return false
+		}
 
 		dataToVerify := fmt.Sprintf("%x\n", txCopy)
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) {
+		if !ecdsa.Verify(&pubKey, []byte(dataToVerify), &r, &s) {
 			return false
 		}
 
@@ -14,11 +14,34 @@ type TXInput struct {
 	pubkey    []byte
 }
 
+// txInputData is the serialized form of a TXInput
+type txInputData struct {
+	TxId      []byte
+	Vout      int
+	Signature []byte
+	PubKey    []byte
+}
+
 // NewTXInput create a new TXInput
 func NewTXInput(txId []byte, vout int, signature []byte, publicKey []byte) *TXInput {
 	return &TXInput{txId, vout, signature, publicKey}
 }
 
+// GobEncode encodes the TXInput, gob can't reach its unexported fields
+func (ti TXInput) GobEncode() ([]byte, error) {
+	return gobEncode(txInputData{ti.txId, ti.vout, ti.signature, ti.pubkey})
+}
+
+// GobDecode decodes a TXInput encoded by GobEncode
+func (ti *TXInput) GobDecode(data []byte) error {
+	var decoded txInputData
+
+	err := gobDecode(data, &decoded)
+	ti.txId, ti.vout, ti.signature, ti.pubkey = decoded.TxId, decoded.Vout, decoded.Signature, decoded.PubKey
+
+	return err
+}
+
 func (ti *TXInput) TxId() []byte {
 	return ti.txId
 }
@@ -14,6 +14,12 @@ type TXOutput struct {
 	pubkeyHash []byte
 }
 
+// txOutputData is the serialized form of a TXOutput
+type txOutputData struct {
+	Value      int
+	PubKeyHash []byte
+}
+
 // NewTXOutput create a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
 	txo := &TXOutput{value, nil}
@@ -22,6 +28,21 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return txo
 }
 
+// GobEncode encodes the TXOutput, gob can't reach its unexported fields
+func (to TXOutput) GobEncode() ([]byte, error) {
+	return gobEncode(txOutputData{to.value, to.pubkeyHash})
+}
+
+// GobDecode decodes a TXOutput encoded by GobEncode
+func (to *TXOutput) GobDecode(data []byte) error {
+	var decoded txOutputData
+
+	err := gobDecode(data, &decoded)
+	to.value, to.pubkeyHash = decoded.Value, decoded.PubKeyHash
+
+	return err
+}
+
 func (to *TXOutput) Value() int {
 	return to.value
 }
@@ -31,9 +52,13 @@ func (to *TXOutput) PubKeyHash() []byte {
 }
 
 // Lock signs the output
+// The address can be in the Base58Check or the Bech32 format
 func (to *TXOutput) Lock(address []byte) {
-	pubKeyHash := utils.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	pubKeyHash, err := utils.DecodeAddress(string(address))
+	if err != nil {
+		log.Panic(err)
+	}
+
 	to.pubkeyHash = pubKeyHash
 }
 
@@ -47,6 +72,16 @@ type TXOutputs struct {
 	outputs []TXOutput
 }
 
+// GobEncode encodes the TXOutputs, gob can't reach its unexported fields
+func (outs TXOutputs) GobEncode() ([]byte, error) {
+	return gobEncode(outs.outputs)
+}
+
+// GobDecode decodes TXOutputs encoded by GobEncode
+func (outs *TXOutputs) GobDecode(data []byte) error {
+	return gobDecode(data, &outs.outputs)
+}
+
 // DeserializeOutputs deserializes TXOutputs
 func DeserializeOutputs(data []byte) TXOutputs {
 	var outputs TXOutputs
@@ -78,6 +113,6 @@ func (outs TXOutputs) Serialize() []byte {
 }
 
 // Add adds TXOutput
-func (outs TXOutputs) Add(txOutput TXOutput) {
+func (outs *TXOutputs) Add(txOutput TXOutput) {
 	outs.outputs = append(outs.outputs, txOutput)
 }
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+)
+
+const (
+	pubKeyHashLen = 20
+	// witnessVersion is the version of the program carried by Bech32 addresses, version 0 uses the Bech32 checksum
+	witnessVersion = 0
+)
+
+var (
+	errEmptyAddress    = errors.New("ERROR: Address is empty")
+	errAddressLength   = errors.New("ERROR: Address has an invalid length")
+	errAddressChecksum = errors.New("ERROR: Address checksum is invalid")
+)
+
+// EncodeAddress returns the Base58Check address of the public key hash on the active network
+func EncodeAddress(pubKeyHash []byte) string {
+	versionedPayload := append([]byte{chaincfg.ActiveParams().PubKeyHashAddrID()}, pubKeyHash...)
+	checksum := Checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+
+	return string(Base58Encode(fullPayload))
+}
+
+// EncodeBech32Address returns the Bech32 address of the public key hash on the active network
+func EncodeBech32Address(pubKeyHash []byte) string {
+	program, err := ConvertBits(pubKeyHash, 8, 5, true)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	address, err := Bech32Encode(chaincfg.ActiveParams().Bech32HRP(), append([]byte{witnessVersion}, program...), Bech32)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return address
+}
+
+// DecodeAddress returns the public key hash a Base58Check or Bech32 address pays to
+// The error tells what is wrong with the address, including when it belongs to another network
+func DecodeAddress(address string) ([]byte, error) {
+	if address == "" {
+		return nil, errEmptyAddress
+	}
+
+	if isBech32Address(address) {
+		return decodeBech32Address(address)
+	}
+
+	return decodeBase58Address(address)
+}
+
+// isBech32Address tells whether the address starts with the human-readable prefix of a known network
+func isBech32Address(address string) bool {
+	lower := strings.ToLower(address)
+
+	for _, params := range chaincfg.Networks() {
+		if strings.HasPrefix(lower, params.Bech32HRP()+string(bech32Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeBase58Address(address string) ([]byte, error) {
+	fullPayload, err := Base58Decode([]byte(address))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fullPayload) != 1+pubKeyHashLen+addressChecksumLen {
+		return nil, errAddressLength
+	}
+
+	versionedPayload := fullPayload[:len(fullPayload)-addressChecksumLen]
+	checksum := fullPayload[len(fullPayload)-addressChecksumLen:]
+
+	if !bytes.Equal(checksum, Checksum(versionedPayload)) {
+		return nil, errAddressChecksum
+	}
+
+	if versionedPayload[0] != chaincfg.ActiveParams().PubKeyHashAddrID() {
+		return nil, fmt.Errorf("ERROR: Address is not a %s address", chaincfg.ActiveParams().Name())
+	}
+
+	return versionedPayload[1:], nil
+}
+
+func decodeBech32Address(address string) ([]byte, error) {
+	hrp, data, encoding, err := Bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if hrp != chaincfg.ActiveParams().Bech32HRP() {
+		return nil, fmt.Errorf("ERROR: Address is not a %s address", chaincfg.ActiveParams().Name())
+	}
+
+	if len(data) == 0 || data[0] != witnessVersion {
+		return nil, errors.New("ERROR: Address has an unsupported version")
+	}
+
+	if encoding != Bech32 {
+		return nil, errors.New("ERROR: Address of version 0 must use the Bech32 checksum")
+	}
+
+	pubKeyHash, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pubKeyHash) != pubKeyHashLen {
+		return nil, errAddressLength
+	}
+
+	return pubKeyHash, nil
+}
@@ -2,12 +2,17 @@ package utils
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"math/big"
 )
 
 var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
 
+var errEmptyBase58 = errors.New("ERROR: Base58 input is empty")
+
 // Base58Encode encodes a byte array to Base58
+// Every leading zero byte is kept as a leading '1'
 func Base58Encode(input []byte) []byte {
 	var result []byte
 
@@ -22,7 +27,11 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	if input[0] == 0x00 {
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -31,20 +40,30 @@ func Base58Encode(input []byte) []byte {
 }
 
 // Base58Decode decodes Base58-encoded data
-func Base58Decode(input []byte) []byte {
+// Characters outside the alphabet are an error instead of being skipped
+func Base58Decode(input []byte) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, errEmptyBase58
+	}
+
 	result := big.NewInt(0)
+	zeros := 0
 
-	for _, b := range input {
+	for i, b := range input {
 		charIdex := bytes.IndexByte(b58Alphabet, b)
+		if charIdex < 0 {
+			return nil, fmt.Errorf("ERROR: Invalid Base58 character %q at position %d", b, i+1)
+		}
+
+		if charIdex == 0 && zeros == i {
+			zeros++
+		}
+
 		result.Mul(result, big.NewInt(58))
 		result.Add(result, big.NewInt(int64(charIdex)))
 	}
 
-	decoded := result.Bytes()
-
-	if input[0] == b58Alphabet[0] {
-		decoded = append([]byte{0x00}, decoded...)
-	}
+	decoded := append(make([]byte, zeros), result.Bytes()...)
 
-	return decoded
+	return decoded, nil
 }
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32Encoding tells which constant the checksum of a Bech32 string is built with
+type Bech32Encoding int
+
+const (
+	Bech32 Bech32Encoding = iota
+	Bech32m
+)
+
+const (
+	bech32Charset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32Separator   = '1'
+	bech32ChecksumLen = 6
+	bech32MaxLen      = 90
+
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+var (
+	errBech32Length    = errors.New("ERROR: Bech32 string has an invalid length")
+	errBech32MixedCase = errors.New("ERROR: Bech32 string mixes upper and lower case")
+	errBech32Separator = errors.New("ERROR: Bech32 string has no human-readable part or no data")
+	errBech32Padding   = errors.New("ERROR: Bech32 data has invalid padding")
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Bech32Encode encodes 5-bit values under a human-readable part with a checksum of the given encoding
+func Bech32Encode(hrp string, data []byte, encoding Bech32Encoding) (string, error) {
+	if len(hrp)+1+len(data)+bech32ChecksumLen > bech32MaxLen {
+		return "", errBech32Length
+	}
+
+	hrp = strings.ToLower(hrp)
+	values := append(append([]byte{}, data...), bech32Checksum(hrp, data, encoding)...)
+
+	var result strings.Builder
+	result.WriteString(hrp)
+	result.WriteByte(bech32Separator)
+
+	for _, value := range values {
+		if value > 31 {
+			return "", fmt.Errorf("ERROR: Bech32 value %d does not fit in 5 bits", value)
+		}
+
+		result.WriteByte(bech32Charset[value])
+	}
+
+	return result.String(), nil
+}
+
+// Bech32Decode decodes a Bech32 or Bech32m string into its human-readable part and 5-bit values
+// A checksum failure caused by a single mistyped character reports the position of that character
+func Bech32Decode(input string) (string, []byte, Bech32Encoding, error) {
+	if len(input) > bech32MaxLen {
+		return "", nil, 0, errBech32Length
+	}
+
+	if strings.ToLower(input) != input && strings.ToUpper(input) != input {
+		return "", nil, 0, errBech32MixedCase
+	}
+
+	input = strings.ToLower(input)
+
+	separator := strings.LastIndexByte(input, bech32Separator)
+	if separator < 1 || separator+bech32ChecksumLen+1 > len(input) {
+		return "", nil, 0, errBech32Separator
+	}
+
+	hrp := input[:separator]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, fmt.Errorf("ERROR: Invalid Bech32 character %q at position %d", hrp[i], i+1)
+		}
+	}
+
+	var values []byte
+	for i := separator + 1; i < len(input); i++ {
+		value := strings.IndexByte(bech32Charset, input[i])
+		if value < 0 {
+			return "", nil, 0, fmt.Errorf("ERROR: Invalid Bech32 character %q at position %d", input[i], i+1)
+		}
+
+		values = append(values, byte(value))
+	}
+
+	encoding, ok := bech32Verify(hrp, values)
+	if !ok {
+		if position, found := locateBech32Typo(hrp, values); found {
+			return "", nil, 0, fmt.Errorf("ERROR: Bech32 checksum is invalid, the character at position %d looks mistyped", separator+1+position+1)
+		}
+
+		return "", nil, 0, errors.New("ERROR: Bech32 checksum is invalid")
+	}
+
+	return hrp, values[:len(values)-bech32ChecksumLen], encoding, nil
+}
+
+// ConvertBits regroups a sequence of fromBits-bit values into toBits-bit values
+// With pad the last group is filled with zeros, without it leftover bits must be zero padding
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var result []byte
+	acc := uint32(0)
+	bits := uint(0)
+	maxValue := uint32(1)<<toBits - 1
+
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, fmt.Errorf("ERROR: Value %d does not fit in %d bits", value, fromBits)
+		}
+
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxValue))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errBech32Padding
+	}
+
+	return result, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	checksum := uint32(1)
+
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				checksum ^= bech32Generator[i]
+			}
+		}
+	}
+
+	return checksum
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+
+	expanded = append(expanded, 0)
+
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+
+	return expanded
+}
+
+func bech32Constant(encoding Bech32Encoding) uint32 {
+	if encoding == Bech32m {
+		return bech32mConst
+	}
+
+	return bech32Const
+}
+
+func bech32Checksum(hrp string, data []byte, encoding Bech32Encoding) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLen)...)
+	polymod := bech32Polymod(values) ^ bech32Constant(encoding)
+
+	checksum := make([]byte, bech32ChecksumLen)
+	for i := range checksum {
+		checksum[i] = byte(polymod >> (5 * (5 - i)) & 31)
+	}
+
+	return checksum
+}
+
+// bech32Verify checks the checksum of the values and tells which encoding it was built with
+func bech32Verify(hrp string, values []byte) (Bech32Encoding, bool) {
+	switch bech32Polymod(append(bech32HRPExpand(hrp), values...)) {
+	case bech32Const:
+		return Bech32, true
+	case bech32mConst:
+		return Bech32m, true
+	}
+
+	return 0, false
+}
+
+// locateBech32Typo finds the single value that makes the checksum valid once replaced
+// The checksum corrects any one substitution, so a match points at the mistyped character
+func locateBech32Typo(hrp string, values []byte) (int, bool) {
+	candidate := make([]byte, len(values))
+
+	for position := range values {
+		copy(candidate, values)
+
+		for value := byte(0); value < 32; value++ {
+			if value == values[position] {
+				continue
+			}
+
+			candidate[position] = value
+			if _, ok := bech32Verify(hrp, candidate); ok {
+				return position, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"math"
+	"math/bits"
+)
+
+const (
+	// A bloom filter is at most MaxBloomFilterSize bytes with MaxBloomHashFuncs hash functions, like BIP 37 ones
+	MaxBloomFilterSize = 36000
+	MaxBloomHashFuncs  = 50
+	// bloomSeedStep spaces the seeds of the hash functions
+	bloomSeedStep = 0xFBA4C795
+)
+
+// BloomFilter tells whether it may contain some data, without false negatives
+// A light client hands one to a node so the node only sends the transactions that may concern it, without telling which
+type BloomFilter struct {
+	filter    []byte
+	hashFuncs uint32
+	tweak     uint32
+}
+
+// bloomFilterData is the serialized form of a BloomFilter
+type bloomFilterData struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+}
+
+// NewBloomFilter creates a bloom filter sized for the number of elements with the false positive rate
+// The tweak changes the hash functions, so the same elements don't give away the same filter
+func NewBloomFilter(elements int, fpRate float64, tweak uint32) *BloomFilter {
+	elements = max(elements, 1)
+
+	size := int(-1 / (math.Ln2 * math.Ln2) * float64(elements) * math.Log(fpRate) / 8)
+	size = min(max(size, 1), MaxBloomFilterSize)
+
+	hashFuncs := int(float64(size*8) / float64(elements) * math.Ln2)
+	hashFuncs = min(max(hashFuncs, 1), MaxBloomHashFuncs)
+
+	return &BloomFilter{make([]byte, size), uint32(hashFuncs), tweak}
+}
+
+// Add adds the data to the filter
+func (bf *BloomFilter) Add(data []byte) {
+	if len(bf.filter) == 0 {
+		return
+	}
+
+	for i := uint32(0); i < bf.hashFuncs; i++ {
+		bit := bf.hash(i, data)
+		bf.filter[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains tells whether the data may have been added to the filter
+func (bf *BloomFilter) Contains(data []byte) bool {
+	if len(bf.filter) == 0 {
+		return false
+	}
+
+	for i := uint32(0); i < bf.hashFuncs; i++ {
+		bit := bf.hash(i, data)
+		if bf.filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsWithinLimits tells whether the filter has bits and hash functions, and is not larger than BIP 37 allows
+func (bf *BloomFilter) IsWithinLimits() bool {
+	return len(bf.filter) > 0 && len(bf.filter) <= MaxBloomFilterSize &&
+		bf.hashFuncs > 0 && bf.hashFuncs <= MaxBloomHashFuncs
+}
+
+// hash returns the bit of the filter the hash function picks for the data
+func (bf *BloomFilter) hash(hashNum uint32, data []byte) uint32 {
+	return murmur3(hashNum*bloomSeedStep+bf.tweak, data) % uint32(len(bf.filter)*8)
+}
+
+// murmur3 returns the 32-bit MurmurHash3 of the data
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	blocks := len(data) / 4
+
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
+
+// GobEncode encodes the BloomFilter, gob can't reach its unexported fields
+func (bf *BloomFilter) GobEncode() ([]byte, error) {
+	var result bytes.Buffer
+
+	err := gob.NewEncoder(&result).Encode(bloomFilterData{bf.filter, bf.hashFuncs, bf.tweak})
+
+	return result.Bytes(), err
+}
+
+// GobDecode decodes a BloomFilter encoded by GobEncode
+func (bf *BloomFilter) GobDecode(data []byte) error {
+	var decoded bloomFilterData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded)
+	bf.filter, bf.hashFuncs, bf.tweak = decoded.Filter, decoded.HashFuncs, decoded.Tweak
+
+	return err
+}
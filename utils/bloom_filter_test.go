@@ -0,0 +1,56 @@
+package utils
+
+import "testing"
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		name string
+		seed uint32
+		data []byte
+		want uint32
+	}{
+		{"empty", 0, nil, 0},
+		{"empty with seed 1", 1, nil, 0x514E28B7},
+		{"empty with all seed bits set", 0xFFFFFFFF, nil, 0x81F16F39},
+		{"four zero bytes", 0, []byte{0, 0, 0, 0}, 0x2362F9DE},
+		{"four bytes set", 0, []byte{0xFF, 0xFF, 0xFF, 0xFF}, 0x76293B50},
+		{"one block", 0, []byte{0x21, 0x43, 0x65, 0x87}, 0xF55B516B},
+		{"three byte tail", 0, []byte{0x21, 0x43, 0x65}, 0x7E4A8634},
+		{"two byte tail", 0, []byte{0x21, 0x43}, 0xA0F7B07A},
+		{"one byte tail", 0, []byte{0x21}, 0x72661CF4},
+		{"block with seed", 0x9747B28C, []byte("aaaa"), 0x5A97808A},
+		{"text", 0x9747B28C, []byte("Hello, world!"), 0x24884CBA},
+		{"long text", 0x9747B28C, []byte("The quick brown fox jumps over the lazy dog"), 0x2FA826CD},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := murmur3(tt.seed, tt.data); got != tt.want {
+				t.Errorf("murmur3(%#x, %q) = %#x, want %#x", tt.seed, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBloomFilterIsWithinLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *BloomFilter
+		want   bool
+	}{
+		{"sized filter", NewBloomFilter(10, 0.0001, 0), true},
+		{"largest filter", &BloomFilter{make([]byte, MaxBloomFilterSize), MaxBloomHashFuncs, 0}, true},
+		{"no bits", &BloomFilter{nil, 1, 0}, false},
+		{"no hash functions", &BloomFilter{make([]byte, 1), 0, 0}, false},
+		{"too many bits", &BloomFilter{make([]byte, MaxBloomFilterSize+1), 1, 0}, false},
+		{"too many hash functions", &BloomFilter{make([]byte, 1), MaxBloomHashFuncs + 1, 0}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.IsWithinLimits(); got != tt.want {
+				t.Errorf("IsWithinLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
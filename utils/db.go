@@ -4,9 +4,17 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+)
+
+const (
+	DefaultDataDir = "./database"
+	dbFile         = "blockchain_%s.db"
 )
 
-const dbFile = "./database/blockchain_%s.db"
+var dataDir = DefaultDataDir
 
 func CheckDB(nodeId string) bool {
 	if _, err := os.Stat(GetDBPath(nodeId)); os.IsNotExist(err) {
@@ -32,5 +40,26 @@ func CreateDB(nodeId string) {
 }
 
 func GetDBPath(nodeId string) string {
-	return fmt.Sprintf(dbFile, nodeId)
+	return DataPath(fmt.Sprintf(dbFile, nodeId))
+}
+
+// SetDataDir sets the directory the data files are kept in
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// DataPath returns the path of a data file of the active network, creating its directory when missing
+// Mainnet files stay in the data directory, the ones of other networks go to a subdirectory named after the network
+func DataPath(fileName string) string {
+	dir := dataDir
+	if chaincfg.ActiveParams().Name() != chaincfg.MainNet {
+		dir = filepath.Join(dataDir, chaincfg.ActiveParams().Name())
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return filepath.Join(dir, fileName)
 }
@@ -1,17 +1,21 @@
 package utils
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"log"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
-const addressChecksumLen = 4
+const (
+	addressChecksumLen = 4
+	privateKeyLen      = 32
+)
 
 // HashPubKey hashes public key
 func HashPubKey(pubKey []byte) []byte {
@@ -28,14 +32,11 @@ func HashPubKey(pubKey []byte) []byte {
 }
 
 // ValidateAddress check if address if valid
+// Both Base58Check and Bech32 addresses are accepted, addresses of another network than the active one are rejected
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
+	_, err := DecodeAddress(address)
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	return err == nil
 }
 
 // Checksum generates a checksum for a public key
@@ -54,7 +55,58 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	publicKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	return *private, CompressPubKey(private.PublicKey)
+}
+
+// PublicKeyBytes returns the public key as the raw X and Y coordinates older wallets store
+func PublicKeyBytes(publicKey ecdsa.PublicKey) []byte {
+	return append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
+}
+
+// CompressPubKey returns the public key in the 33-byte compressed SEC1 format
+func CompressPubKey(publicKey ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(elliptic.P256(), publicKey.X, publicKey.Y)
+}
+
+// ParsePubKey parses a public key in the compressed or uncompressed SEC1 format, or as raw X and Y coordinates
+func ParsePubKey(pubKey []byte) (ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	var x, y *big.Int
+
+	switch {
+	case len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03):
+		x, y = elliptic.UnmarshalCompressed(curve, pubKey)
+	case len(pubKey) == 65 && pubKey[0] == 0x04:
+		x, y = elliptic.Unmarshal(curve, pubKey)
+	case len(pubKey) > 0 && len(pubKey)%2 == 0:
+		x = new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+		y = new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	}
+
+	if x == nil || !curve.IsOnCurve(x, y) {
+		return ecdsa.PublicKey{}, errors.New("invalid public key")
+	}
+
+	return ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// PrivateKeyToBytes returns the 32-byte scalar of a private key
+func PrivateKeyToBytes(privateKey ecdsa.PrivateKey) []byte {
+	return privateKey.D.FillBytes(make([]byte, privateKeyLen))
+}
+
+// PrivateKeyFromBytes rebuilds a P-256 private key from its scalar
+func PrivateKeyFromBytes(d []byte) (ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	scalar := new(big.Int).SetBytes(d)
+
+	if len(d) != privateKeyLen || scalar.Sign() == 0 || scalar.Cmp(curve.Params().N) >= 0 {
+		return ecdsa.PrivateKey{}, errors.New("invalid private key")
+	}
+
+	privateKey := ecdsa.PrivateKey{D: scalar}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(d)
 
-	return *private, publicKey
+	return privateKey, nil
 }
@@ -10,6 +10,7 @@ type MerkleTree struct {
 }
 
 // NewMerkleTree creates a new Merkle tree from a sequence of data
+// The last node of a level with an odd number of nodes is paired with itself, a single datum included
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
@@ -22,7 +23,11 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
@@ -53,12 +58,9 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
 
 	if left == nil && right == nil {
-		hash := sha256.Sum256(data)
-		mNode.data = hash[:]
+		mNode.data = merkleLeaf(data)
 	} else {
-		prevHashes := append(left.data, right.data...)
-		hash := sha256.Sum256(prevHashes)
-		mNode.data = hash[:]
+		mNode.data = merkleParent(left.data, right.data)
 	}
 
 	mNode.left = left
@@ -78,3 +80,17 @@ func (mNode *MerkleNode) Right() *MerkleNode {
 func (mNode *MerkleNode) Data() []byte {
 	return mNode.data
 }
+
+// merkleLeaf returns the hash of a leaf of a Merkle tree
+func merkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// merkleParent returns the hash of the node of a Merkle tree with the children hashes
+func merkleParent(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+
+	return hash[:]
+}
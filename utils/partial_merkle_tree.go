@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+var errBadPartialMerkleTree = errors.New("ERROR: The partial Merkle tree is malformed")
+
+// PartialMerkleTree proves that some data are the leaves of a Merkle tree, without the other leaves
+// It walks the tree depth first: a bit tells whether a node is above a matched leaf, the hash of every node
+// that is not, or that is a matched leaf, is kept
+type PartialMerkleTree struct {
+	total  int
+	bits   []bool
+	hashes [][]byte
+}
+
+// partialMerkleTreeData is the serialized form of a PartialMerkleTree
+type partialMerkleTreeData struct {
+	Total  int
+	Bits   []bool
+	Hashes [][]byte
+}
+
+// NewPartialMerkleTree builds the partial tree of the Merkle tree of the data proving the data whose match is set
+func NewPartialMerkleTree(data [][]byte, matches []bool) *PartialMerkleTree {
+	pmt := &PartialMerkleTree{total: len(data)}
+
+	var leaves [][]byte
+	for _, datum := range data {
+		leaves = append(leaves, merkleLeaf(datum))
+	}
+
+	pmt.build(pmt.height(), 0, leaves, matches)
+
+	return pmt
+}
+
+// width returns how many nodes the level at the height above the leaves has
+func (pmt *PartialMerkleTree) width(height int) int {
+	return (pmt.total + (1 << height) - 1) >> height
+}
+
+// height returns the height of the root, NewMerkleTree pairs a single leaf with itself so the root is never a leaf
+func (pmt *PartialMerkleTree) height() int {
+	height := 1
+	for pmt.width(height) > 1 {
+		height++
+	}
+
+	return height
+}
+
+// hash computes the hash of the node at the height and position from the leaves
+func (pmt *PartialMerkleTree) hash(height, pos int, leaves [][]byte) []byte {
+	if height == 0 {
+		return leaves[pos]
+	}
+
+	left := pmt.hash(height-1, pos*2, leaves)
+	right := left
+
+	if pos*2+1 < pmt.width(height-1) {
+		right = pmt.hash(height-1, pos*2+1, leaves)
+	}
+
+	return merkleParent(left, right)
+}
+
+func (pmt *PartialMerkleTree) build(height, pos int, leaves [][]byte, matches []bool) {
+	parentOfMatch := false
+	for i := pos << height; i < (pos+1)<<height && i < pmt.total; i++ {
+		parentOfMatch = parentOfMatch || matches[i]
+	}
+
+	pmt.bits = append(pmt.bits, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		pmt.hashes = append(pmt.hashes, pmt.hash(height, pos, leaves))
+		return
+	}
+
+	pmt.build(height-1, pos*2, leaves, matches)
+
+	if pos*2+1 < pmt.width(height-1) {
+		pmt.build(height-1, pos*2+1, leaves, matches)
+	}
+}
+
+// ExtractMatches returns the Merkle root the tree proves, the hashes of the matched leaves and their positions
+// A tree with unused bits or hashes, or two equal children, is rejected: it could make a leaf look like it is in the tree twice
+func (pmt *PartialMerkleTree) ExtractMatches() ([]byte, [][]byte, []int, error) {
+	if pmt.total == 0 || len(pmt.hashes) > pmt.total || len(pmt.bits) < len(pmt.hashes) {
+		return nil, nil, nil, errBadPartialMerkleTree
+	}
+
+	var matches [][]byte
+	var indexes []int
+	bitsUsed, hashesUsed := 0, 0
+
+	root, err := pmt.extract(pmt.height(), 0, &bitsUsed, &hashesUsed, &matches, &indexes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if bitsUsed != len(pmt.bits) || hashesUsed != len(pmt.hashes) {
+		return nil, nil, nil, errBadPartialMerkleTree
+	}
+
+	return root, matches, indexes, nil
+}
+
+func (pmt *PartialMerkleTree) extract(height, pos int, bitsUsed, hashesUsed *int, matches *[][]byte, indexes *[]int) ([]byte, error) {
+	if *bitsUsed >= len(pmt.bits) {
+		return nil, errBadPartialMerkleTree
+	}
+
+	parentOfMatch := pmt.bits[*bitsUsed]
+	*bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if *hashesUsed >= len(pmt.hashes) {
+			return nil, errBadPartialMerkleTree
+		}
+
+		hash := pmt.hashes[*hashesUsed]
+		*hashesUsed++
+
+		if height == 0 && parentOfMatch {
+			*matches = append(*matches, hash)
+			*indexes = append(*indexes, pos)
+		}
+
+		return hash, nil
+	}
+
+	left, err := pmt.extract(height-1, pos*2, bitsUsed, hashesUsed, matches, indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	right := left
+
+	if pos*2+1 < pmt.width(height-1) {
+		right, err = pmt.extract(height-1, pos*2+1, bitsUsed, hashesUsed, matches, indexes)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(left, right) {
+			return nil, errBadPartialMerkleTree
+		}
+	}
+
+	return merkleParent(left, right), nil
+}
+
+// MerkleLeaf returns the hash a datum has as a leaf of a Merkle tree, the one ExtractMatches returns for it
+func MerkleLeaf(data []byte) []byte {
+	return merkleLeaf(data)
+}
+
+// GobEncode encodes the PartialMerkleTree, gob can't reach its unexported fields
+func (pmt *PartialMerkleTree) GobEncode() ([]byte, error) {
+	var result bytes.Buffer
+
+	err := gob.NewEncoder(&result).Encode(partialMerkleTreeData{pmt.total, pmt.bits, pmt.hashes})
+
+	return result.Bytes(), err
+}
+
+// GobDecode decodes a PartialMerkleTree encoded by GobEncode
+func (pmt *PartialMerkleTree) GobDecode(data []byte) error {
+	var decoded partialMerkleTreeData
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded)
+	pmt.total, pmt.bits, pmt.hashes = decoded.Total, decoded.Bits, decoded.Hashes
+
+	return err
+}
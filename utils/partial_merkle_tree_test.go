@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// testLeaves returns n distinct data to build trees from
+func testLeaves(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		data = append(data, []byte(fmt.Sprintf("leaf %d", i)))
+	}
+
+	return data
+}
+
+func TestPartialMerkleTreeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		leaves  int
+		matches []bool
+	}{
+		{"single leaf", 1, []bool{true}},
+		{"single leaf not matched", 1, []bool{false}},
+		{"two leaves", 2, []bool{false, true}},
+		{"two leaves both matched", 2, []bool{true, true}},
+		{"three leaves", 3, []bool{true, false, true}},
+		{"three leaves last matched", 3, []bool{false, false, true}},
+		{"seven leaves", 7, []bool{false, true, false, false, true, false, true}},
+		{"seven leaves none matched", 7, make([]bool, 7)},
+		{"seven leaves all matched", 7, []bool{true, true, true, true, true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testLeaves(tt.leaves)
+
+			var wantHashes [][]byte
+			var wantIndexes []int
+			for i, matched := range tt.matches {
+				if matched {
+					wantHashes = append(wantHashes, merkleLeaf(data[i]))
+					wantIndexes = append(wantIndexes, i)
+				}
+			}
+
+			root, hashes, indexes, err := NewPartialMerkleTree(data, tt.matches).ExtractMatches()
+			if err != nil {
+				t.Fatalf("ExtractMatches() error = %v", err)
+			}
+
+			if want := NewMerkleTree(data).RootNode().Data(); !bytes.Equal(root, want) {
+				t.Errorf("ExtractMatches() root = %x, want %x", root, want)
+			}
+
+			if !slices.EqualFunc(hashes, wantHashes, bytes.Equal) {
+				t.Errorf("ExtractMatches() hashes = %x, want %x", hashes, wantHashes)
+			}
+
+			if !slices.Equal(indexes, wantIndexes) {
+				t.Errorf("ExtractMatches() indexes = %v, want %v", indexes, wantIndexes)
+			}
+		})
+	}
+}
+
+func TestPartialMerkleTreeRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		pmt  func() *PartialMerkleTree
+	}{
+		{"no leaves", func() *PartialMerkleTree {
+			return &PartialMerkleTree{}
+		}},
+		{"duplicated child", func() *PartialMerkleTree {
+			data := [][]byte{[]byte("leaf"), []byte("leaf")}
+			return NewPartialMerkleTree(data, []bool{true, false})
+		}},
+		{"duplicated last leaves", func() *PartialMerkleTree {
+			data := append(testLeaves(3), []byte("leaf 2"))
+			return NewPartialMerkleTree(data, []bool{false, false, true, false})
+		}},
+		{"trailing bits", func() *PartialMerkleTree {
+			pmt := NewPartialMerkleTree(testLeaves(7), []bool{false, true, false, false, false, false, false})
+			pmt.bits = append(pmt.bits, false)
+			return pmt
+		}},
+		{"trailing hashes", func() *PartialMerkleTree {
+			pmt := NewPartialMerkleTree(testLeaves(7), []bool{false, true, false, false, false, false, false})
+			pmt.hashes = append(pmt.hashes, merkleLeaf([]byte("extra")))
+			return pmt
+		}},
+		{"missing hashes", func() *PartialMerkleTree {
+			pmt := NewPartialMerkleTree(testLeaves(3), []bool{true, false, false})
+			pmt.hashes = pmt.hashes[:len(pmt.hashes)-1]
+			return pmt
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := tt.pmt().ExtractMatches(); err == nil {
+				t.Error("ExtractMatches() error = nil, want an error")
+			}
+		})
+	}
+}
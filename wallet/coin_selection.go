@@ -0,0 +1,237 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+)
+
+const (
+	StrategyBranchAndBound = "bnb"
+	StrategyLargestFirst   = "largest"
+	StrategySmallestFirst  = "smallest"
+	StrategyRandom         = "random"
+
+	txOverheadSize = 10
+	txInputSize    = 170
+	txOutputSize   = 30
+
+	bnbMaxTries = 100000
+)
+
+var errInsufficientFunds = errors.New("ERROR: Not enough funds")
+
+// CoinSelector picks the unspent outputs that fund a transaction
+type CoinSelector interface {
+	Select(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error)
+}
+
+// CoinSelection is the result of a coin selection
+type CoinSelection struct {
+	inputs []chainstate.UnspentOutput
+	total  int
+	fee    int
+	change int
+}
+
+// NewCoinSelector returns the CoinSelector implementing the strategy
+func NewCoinSelector(strategy string) (CoinSelector, error) {
+	switch strategy {
+	case StrategyBranchAndBound:
+		return &branchAndBound{&largestFirst{}}, nil
+	case StrategyLargestFirst:
+		return &largestFirst{}, nil
+	case StrategySmallestFirst:
+		return &smallestFirst{}, nil
+	case StrategyRandom:
+		return &randomSelection{}, nil
+	}
+
+	return nil, fmt.Errorf("ERROR: Unknown coin selection strategy %q", strategy)
+}
+
+// EstimateFee returns the fee of a transaction with the given number of inputs and outputs
+// feeRate is expressed in coins per 1000 bytes
+func EstimateFee(inputs, outputs, feeRate int) int {
+	size := txOverheadSize + inputs*txInputSize + outputs*txOutputSize
+
+	return (size*feeRate + 999) / 1000
+}
+
+func (cs *CoinSelection) Inputs() []chainstate.UnspentOutput {
+	return cs.inputs
+}
+
+func (cs *CoinSelection) Total() int {
+	return cs.total
+}
+
+func (cs *CoinSelection) Fee() int {
+	return cs.fee
+}
+
+func (cs *CoinSelection) Change() int {
+	return cs.change
+}
+
+// String returns a human-readable representation of a coin selection
+func (cs *CoinSelection) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("Selected %d input(s), total %d, fee %d, change %d:", len(cs.inputs), cs.total, cs.fee, cs.change))
+
+	for _, in := range cs.inputs {
+		lines = append(lines, fmt.Sprintf("  %x:%d  %d", in.TxId(), in.Index(), in.Value()))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// largestFirst spends the biggest outputs first, keeping the number of inputs low
+type largestFirst struct{}
+
+func (s *largestFirst) Select(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error) {
+	sorted := append([]chainstate.UnspentOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value() > sorted[j].Value() })
+
+	return accumulate(sorted, amount, outputs, feeRate)
+}
+
+// smallestFirst spends the smallest outputs first, consolidating dust
+type smallestFirst struct{}
+
+func (s *smallestFirst) Select(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error) {
+	sorted := append([]chainstate.UnspentOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value() < sorted[j].Value() })
+
+	return accumulate(sorted, amount, outputs, feeRate)
+}
+
+// randomSelection spends outputs in random order, which makes wallet clustering harder
+type randomSelection struct{}
+
+func (s *randomSelection) Select(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error) {
+	shuffled := append([]chainstate.UnspentOutput{}, utxos...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return accumulate(shuffled, amount, outputs, feeRate)
+}
+
+// branchAndBound searches for an input set that pays the amount and fee without a change output
+// When no such set exists it falls back to another strategy
+type branchAndBound struct {
+	fallback CoinSelector
+}
+
+func (s *branchAndBound) Select(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error) {
+	inputFee := EstimateFee(1, 0, feeRate) - EstimateFee(0, 0, feeRate)
+	costOfChange := EstimateFee(0, 1, feeRate) - EstimateFee(0, 0, feeRate) + inputFee
+	target := amount + EstimateFee(0, outputs, feeRate)
+
+	var candidates []chainstate.UnspentOutput
+	for _, utxo := range utxos {
+		if utxo.Value()-inputFee > 0 {
+			candidates = append(candidates, utxo)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Value() > candidates[j].Value() })
+
+	// remaining[i] is the effective value still available from candidates[i:]
+	remaining := make([]int, len(candidates)+1)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + candidates[i].Value() - inputFee
+	}
+
+	var best []int
+	var current []int
+	bestExcess := -1
+	tries := 0
+
+	var search func(depth, value int) bool
+	search = func(depth, value int) bool {
+		tries++
+		if tries > bnbMaxTries || value > target+costOfChange || value+remaining[depth] < target {
+			return false
+		}
+
+		if value >= target {
+			if bestExcess < 0 || value-target < bestExcess {
+				best = append([]int{}, current...)
+				bestExcess = value - target
+			}
+
+			return bestExcess == 0
+		}
+
+		if depth == len(candidates) {
+			return false
+		}
+
+		current = append(current, depth)
+		if search(depth+1, value+candidates[depth].Value()-inputFee) {
+			return true
+		}
+		current = current[:len(current)-1]
+
+		// Skipping an output equal to the one just excluded leads to the same sums
+		next := depth + 1
+		for next < len(candidates) && candidates[next].Value() == candidates[depth].Value() {
+			next++
+		}
+
+		return search(next, value)
+	}
+	search(0, 0)
+
+	if best == nil {
+		if s.fallback == nil {
+			return nil, errInsufficientFunds
+		}
+
+		return s.fallback.Select(utxos, amount, outputs, feeRate)
+	}
+
+	selection := &CoinSelection{}
+	for _, idx := range best {
+		selection.inputs = append(selection.inputs, candidates[idx])
+		selection.total += candidates[idx].Value()
+	}
+	// The excess stays below the cost of a change output, so it goes to the miner
+	selection.fee = selection.total - amount
+
+	return selection, nil
+}
+
+// accumulate takes outputs in order until the amount, the fee and a change output are covered
+func accumulate(utxos []chainstate.UnspentOutput, amount, outputs, feeRate int) (*CoinSelection, error) {
+	selection := &CoinSelection{}
+	dustLimit := EstimateFee(1, 0, feeRate) - EstimateFee(0, 0, feeRate)
+
+	for _, utxo := range utxos {
+		selection.inputs = append(selection.inputs, utxo)
+		selection.total += utxo.Value()
+
+		feeWithoutChange := EstimateFee(len(selection.inputs), outputs, feeRate)
+		if selection.total < amount+feeWithoutChange {
+			continue
+		}
+
+		feeWithChange := EstimateFee(len(selection.inputs), outputs+1, feeRate)
+		change := selection.total - amount - feeWithChange
+
+		if change > dustLimit {
+			selection.fee = feeWithChange
+			selection.change = change
+		} else {
+			selection.fee = selection.total - amount
+		}
+
+		return selection, nil
+	}
+
+	return nil, errInsufficientFunds
+}
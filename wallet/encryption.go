@@ -0,0 +1,326 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	saltLen      = 16
+	keyLen       = 32
+	checkMessage = "wallet passphrase check"
+)
+
+var (
+	errWalletEncrypted    = errors.New("ERROR: Wallet is already encrypted")
+	errWalletNotEncrypted = errors.New("ERROR: Wallet is not encrypted")
+	errWalletLocked       = errors.New("ERROR: Wallet is locked")
+	errWrongPassphrase    = errors.New("ERROR: The passphrase is incorrect")
+	errEmptyPassphrase    = errors.New("ERROR: The passphrase must not be empty")
+)
+
+// Encryption holds the parameters deriving the wallet key from its passphrase
+// Private keys are sealed with AES-256-GCM under a key derived with scrypt
+type Encryption struct {
+	Salt  []byte
+	N     int
+	R     int
+	P     int
+	Check []byte
+}
+
+// newEncryption creates fresh encryption parameters and the key they derive from the passphrase
+func newEncryption(passphrase string) (*Encryption, []byte, error) {
+	if passphrase == "" {
+		return nil, nil, errEmptyPassphrase
+	}
+
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryption := &Encryption{Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+
+	key, err := encryption.deriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryption.Check, err = seal(key, []byte(checkMessage), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryption, key, nil
+}
+
+// deriveKey derives the key from the passphrase and checks it against the stored check value
+func (e *Encryption) deriveKey(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Check != nil {
+		_, err = open(key, e.Check, nil)
+		if err != nil {
+			return nil, errWrongPassphrase
+		}
+	}
+
+	return key, nil
+}
+
+// IsEncrypted tells whether the private keys are stored encrypted
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.Encryption != nil
+}
+
+// IsLocked tells whether the private keys are unavailable until the wallet is unlocked
+func (ws *Wallets) IsLocked() bool {
+	ws.lockIfExpired()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.IsEncrypted() && ws.key == nil
+}
+
+// Encrypt encrypts every private key with the passphrase and locks the wallet
+func (ws *Wallets) Encrypt(passphrase string) error {
+	if ws.IsEncrypted() {
+		return errWalletEncrypted
+	}
+
+	encryption, key, err := newEncryption(passphrase)
+	if err != nil {
+		return err
+	}
+
+	err = ws.sealKeys(key)
+	if err != nil {
+		return err
+	}
+
+	ws.Encryption = encryption
+	ws.Lock()
+
+	return nil
+}
+
+// Unlock decrypts the private keys so the wallet can sign
+// The wallet locks itself again the first time its keys are needed after the timeout elapsed, a zero timeout keeps it unlocked until Lock
+func (ws *Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	if !ws.IsEncrypted() {
+		return errWalletNotEncrypted
+	}
+
+	key, err := ws.Encryption.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	privateKeys := make(map[string]ecdsa.PrivateKey)
+
+	for address, wallet := range ws.Wallets {
+		if wallet.EncryptedKey == nil {
+			continue
+		}
+
+		d, err := open(key, wallet.EncryptedKey, wallet.PublicKey)
+		if err != nil {
+			return err
+		}
+
+		privateKeys[address], err = utils.PrivateKeyFromBytes(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	var entropy []byte
+	if ws.IsHD() {
+		entropy, err = ws.HDChain.openEntropy(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for address, privateKey := range privateKeys {
+		ws.Wallets[address].PrivateKey = privateKey
+	}
+
+	if ws.IsHD() {
+		ws.HDChain.Entropy = entropy
+	}
+
+	ws.key = key
+	ws.lockAt = time.Time{}
+
+	if timeout > 0 {
+		ws.lockAt = time.Now().Add(timeout)
+	}
+
+	return nil
+}
+
+// lockIfExpired locks the wallet once the unlock timeout has elapsed
+// It runs before the keys are used rather than on a timer, so the keys are never wiped while a signature is being made
+func (ws *Wallets) lockIfExpired() {
+	ws.mu.Lock()
+	expired := !ws.lockAt.IsZero() && time.Now().After(ws.lockAt)
+	ws.mu.Unlock()
+
+	if expired {
+		ws.Lock()
+	}
+}
+
+// Lock wipes the decrypted private keys from memory
+func (ws *Wallets) Lock() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.IsEncrypted() {
+		return
+	}
+
+	for _, wallet := range ws.Wallets {
+		if wallet.PrivateKey.D != nil {
+			wallet.PrivateKey.D.SetInt64(0)
+		}
+
+		wallet.PrivateKey = ecdsa.PrivateKey{}
+	}
+
+	if ws.IsHD() {
+		for i := range ws.HDChain.Entropy {
+			ws.HDChain.Entropy[i] = 0
+		}
+
+		ws.HDChain.Entropy = nil
+	}
+
+	for i := range ws.key {
+		ws.key[i] = 0
+	}
+
+	ws.key = nil
+	ws.lockAt = time.Time{}
+}
+
+// ChangePassphrase re-encrypts the private keys under a new passphrase
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	err := ws.Unlock(oldPassphrase, 0)
+	if err != nil {
+		return err
+	}
+
+	defer ws.Lock()
+
+	encryption, key, err := newEncryption(newPassphrase)
+	if err != nil {
+		return err
+	}
+
+	err = ws.sealKeys(key)
+	if err != nil {
+		return err
+	}
+
+	ws.Encryption = encryption
+
+	return nil
+}
+
+// sealKeys encrypts the private key of every wallet holding one and the HD seed
+func (ws *Wallets) sealKeys(key []byte) error {
+	for _, wallet := range ws.Wallets {
+		err := wallet.sealKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ws.IsHD() {
+		return ws.HDChain.sealEntropy(key)
+	}
+
+	return nil
+}
+
+// sealKey encrypts the private key, binding it to the public key
+func (w *Wallet) sealKey(key []byte) error {
+	if w.PrivateKey.D == nil {
+		return nil
+	}
+
+	encryptedKey, err := seal(key, utils.PrivateKeyToBytes(w.PrivateKey), w.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	w.EncryptedKey = encryptedKey
+
+	return nil
+}
+
+// seal encrypts the plaintext and prepends the random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts data produced by seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errWrongPassphrase
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,152 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+// HardenedOffset is added to a child index to request hardened derivation
+const HardenedOffset = uint32(0x80000000)
+
+// masterKeySalt is the HMAC key SLIP-0010 assigns to the P-256 curve
+var masterKeySalt = []byte("Nist256p1 seed")
+
+// ExtendedKey is a private key with the chain code needed to derive its children
+// Derivation follows BIP32 on the P-256 curve as specified by SLIP-0010
+type ExtendedKey struct {
+	key       []byte
+	chainCode []byte
+	depth     int
+}
+
+// NewMasterKey derives the root ExtendedKey from a seed
+func NewMasterKey(seed []byte) *ExtendedKey {
+	n := elliptic.P256().Params().N
+	data := seed
+
+	for {
+		mac := hmac.New(sha512.New, masterKeySalt)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return &ExtendedKey{sum[:32], sum[32:], 0}
+		}
+
+		data = sum
+	}
+}
+
+// Child derives the child key at the index, indexes from HardenedOffset on are hardened
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey {
+	n := elliptic.P256().Params().N
+
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		data = k.compressedPublicKey()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, new(big.Int).SetBytes(k.key))
+		child.Mod(child, n)
+
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			return &ExtendedKey{child.FillBytes(make([]byte, 32)), sum[32:], k.depth + 1}
+		}
+
+		// An invalid child key is retried with the right half of the digest
+		data = binary.BigEndian.AppendUint32(append([]byte{0x01}, sum[32:]...), index)
+	}
+}
+
+// Derive follows the path from this key
+func (k *ExtendedKey) Derive(path []uint32) *ExtendedKey {
+	key := k
+
+	for _, index := range path {
+		key = key.Child(index)
+	}
+
+	return key
+}
+
+// PrivateKey returns the ECDSA private key
+func (k *ExtendedKey) PrivateKey() ecdsa.PrivateKey {
+	privateKey, err := utils.PrivateKeyFromBytes(k.key)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return privateKey
+}
+
+func (k *ExtendedKey) compressedPublicKey() []byte {
+	privateKey := k.PrivateKey()
+
+	return elliptic.MarshalCompressed(privateKey.Curve, privateKey.X, privateKey.Y)
+}
+
+// ParsePath parses a derivation path such as m/44'/0'/0'/0/1
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("ERROR: Derivation path %q must start with m", path)
+	}
+
+	var indexes []uint32
+
+	for _, part := range parts[1:] {
+		// One trailing ' or h marks a hardened index, what is left must be the decimal index
+		digits, hardened := strings.CutSuffix(part, "'")
+		if !hardened {
+			digits, hardened = strings.CutSuffix(part, "h")
+		}
+
+		index, err := strconv.ParseUint(digits, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("ERROR: Invalid index %q in derivation path %q", part, path)
+		}
+
+		if hardened {
+			index += uint64(HardenedOffset)
+		}
+
+		indexes = append(indexes, uint32(index))
+	}
+
+	return indexes, nil
+}
+
+// FormatPath formats derivation indexes as a path
+func FormatPath(indexes []uint32) string {
+	parts := []string{"m"}
+
+	for _, index := range indexes {
+		if index >= HardenedOffset {
+			parts = append(parts, fmt.Sprintf("%d'", index-HardenedOffset))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d", index))
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
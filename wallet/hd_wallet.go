@@ -0,0 +1,219 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	DefaultGapLimit    = 20
+	defaultAccountPath = "m/44'/0'/0'"
+	externalChain      = 0
+)
+
+var (
+	errNotHD        = errors.New("ERROR: Wallet is not hierarchical deterministic")
+	errAlreadyHD    = errors.New("ERROR: Wallet already has a hierarchical deterministic seed")
+	hdChainAuthData = []byte("hd chain")
+)
+
+// HDChain holds the entropy every hierarchical deterministic address is derived from
+// The entropy is what the mnemonic phrase encodes, an encrypted wallet file only keeps EncryptedEntropy
+type HDChain struct {
+	Entropy          []byte
+	EncryptedEntropy []byte
+	AccountPath      string
+	NextIndex        uint32
+}
+
+// IsHD tells whether new addresses are derived from a seed
+func (ws *Wallets) IsHD() bool {
+	return ws.HDChain != nil
+}
+
+// InitHDChain makes the wallet hierarchical deterministic and returns the mnemonic phrase of its seed
+// Passing nil entropy generates a new seed
+func (ws *Wallets) InitHDChain(entropy []byte) (string, error) {
+	if ws.IsHD() {
+		return "", errAlreadyHD
+	}
+
+	var mnemonic string
+	var err error
+
+	if entropy == nil {
+		mnemonic, entropy, err = NewMnemonic()
+	} else {
+		mnemonic, err = EntropyToMnemonic(entropy)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	hdChain := &HDChain{Entropy: entropy, AccountPath: defaultAccountPath}
+
+	if ws.IsEncrypted() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+
+		if ws.key == nil {
+			return "", errWalletLocked
+		}
+
+		err = hdChain.sealEntropy(ws.key)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ws.HDChain = hdChain
+
+	return mnemonic, nil
+}
+
+// Mnemonic returns the recovery phrase of the seed
+func (ws *Wallets) Mnemonic() (string, error) {
+	if !ws.IsHD() {
+		return "", errNotHD
+	}
+
+	ws.lockIfExpired()
+
+	if ws.HDChain.Entropy == nil {
+		return "", errWalletLocked
+	}
+
+	return EntropyToMnemonic(ws.HDChain.Entropy)
+}
+
+// DeriveWallet adds the wallet derived at the path and returns its address
+func (ws *Wallets) DeriveWallet(path string) (string, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	masterKey, err := ws.masterKey()
+	if err != nil {
+		return "", err
+	}
+
+	privateKey := masterKey.Derive(indexes).PrivateKey()
+	wallet := &Wallet{PrivateKey: privateKey, PublicKey: utils.CompressPubKey(privateKey.PublicKey), Path: FormatPath(indexes)}
+
+	return ws.addWallet(wallet), nil
+}
+
+// Rediscover derives the receiving addresses in order until gapLimit consecutive ones were never used on chain
+// The used addresses are added to the wallet and their count is returned
+func (ws *Wallets) Rediscover(bc *blockchain.Blockchain, gapLimit int) (int, error) {
+	masterKey, err := ws.masterKey()
+	if err != nil {
+		return 0, err
+	}
+
+	indexes, err := ParsePath(ws.receivingPath())
+	if err != nil {
+		return 0, err
+	}
+
+	chainKey := masterKey.Derive(indexes)
+	used := usedPubKeyHashes(bc)
+	lastUsed := -1
+
+	for index := 0; index-lastUsed <= gapLimit; index++ {
+		privateKey := chainKey.Child(uint32(index)).PrivateKey()
+		pubKeyHash := utils.HashPubKey(utils.CompressPubKey(privateKey.PublicKey))
+
+		if used[hex.EncodeToString(pubKeyHash)] {
+			lastUsed = index
+		}
+	}
+
+	for index := 0; index <= lastUsed; index++ {
+		_, err := ws.DeriveWallet(fmt.Sprintf("%s/%d", ws.receivingPath(), index))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if uint32(lastUsed+1) > ws.HDChain.NextIndex {
+		ws.HDChain.NextIndex = uint32(lastUsed + 1)
+	}
+
+	return lastUsed + 1, nil
+}
+
+// deriveNextWallet derives the next unused receiving address
+func (ws *Wallets) deriveNextWallet() (string, error) {
+	address, err := ws.DeriveWallet(fmt.Sprintf("%s/%d", ws.receivingPath(), ws.HDChain.NextIndex))
+	if err != nil {
+		return "", err
+	}
+
+	ws.HDChain.NextIndex++
+
+	return address, nil
+}
+
+// receivingPath returns the path of the chain receiving addresses are derived on
+func (ws *Wallets) receivingPath() string {
+	return fmt.Sprintf("%s/%d", ws.HDChain.AccountPath, externalChain)
+}
+
+func (ws *Wallets) masterKey() (*ExtendedKey, error) {
+	mnemonic, err := ws.Mnemonic()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMasterKey(MnemonicToSeed(mnemonic, "")), nil
+}
+
+// sealEntropy encrypts the entropy of the seed
+func (hc *HDChain) sealEntropy(key []byte) error {
+	if hc.Entropy == nil {
+		return nil
+	}
+
+	encryptedEntropy, err := seal(key, hc.Entropy, hdChainAuthData)
+	if err != nil {
+		return err
+	}
+
+	hc.EncryptedEntropy = encryptedEntropy
+
+	return nil
+}
+
+// openEntropy decrypts the entropy of the seed
+func (hc *HDChain) openEntropy(key []byte) ([]byte, error) {
+	return open(key, hc.EncryptedEntropy, hdChainAuthData)
+}
+
+// usedPubKeyHashes returns the hex-encoded public key hashes that outputs on chain are locked with
+func usedPubKeyHashes(bc *blockchain.Blockchain) map[string]bool {
+	used := make(map[string]bool)
+	iterator := bc.Iterator()
+
+	for {
+		block := iterator.Next()
+
+		for _, tx := range block.Transactions() {
+			for _, out := range tx.Vout() {
+				used[hex.EncodeToString(out.PubKeyHash())] = true
+			}
+		}
+
+		if len(block.PrevBlockHash()) == 0 {
+			break
+		}
+	}
+
+	return used
+}
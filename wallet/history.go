@@ -0,0 +1,383 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	historyFile = "history_%s.dat"
+
+	// RescanCheckpointInterval is the number of blocks a rescan connects between two checkpoints
+	RescanCheckpointInterval = 100
+)
+
+var errNoRescan = errors.New("ERROR: There is no interrupted rescan to resume")
+
+// History is the ledger of the transactions touching the wallet addresses
+// Blocks holds the hash of every block applied to the ledger by height, so blocks that left the main chain can be undone
+// Rescanning is set while a rescan starting at RescanFrom has not reached the tip yet
+// Pending holds the transactions the wallet sent that are not confirmed yet, by hex-encoded ID
+type History struct {
+	Blocks     [][]byte
+	Entries    []*HistoryEntry
+	Outputs    map[string]*HistoryOutput
+	RescanFrom int
+	Rescanning bool
+	Pending    map[string]*PendingTransaction
+}
+
+// HistoryUpdater keeps the history of the wallet of a node in line with the chain the node follows
+type HistoryUpdater struct {
+	nodeID string
+}
+
+// NewHistoryUpdater creates a HistoryUpdater for the wallet of the node
+func NewHistoryUpdater(nodeID string) *HistoryUpdater {
+	return &HistoryUpdater{nodeID}
+}
+
+// ChainChanged syncs the history with the chain and saves it, blocks that left the main chain are undone on the way
+// The wallet file is read every time, so addresses added since are followed too
+func (hu *HistoryUpdater) ChainChanged(bc *blockchain.Blockchain) error {
+	wallets, err := NewWallets(hu.nodeID)
+	if err != nil {
+		return err
+	}
+
+	history, err := NewHistory(hu.nodeID)
+	if err != nil {
+		return err
+	}
+
+	history.Sync(bc, wallets)
+	history.SaveToFile(hu.nodeID)
+
+	return nil
+}
+
+// HistoryEntry is a confirmed transaction touching the wallet
+// Amounts holds the net amount of the transaction for every wallet address involved
+type HistoryEntry struct {
+	TxID           []byte
+	BlockHash      []byte
+	Height         int
+	Timestamp      int64
+	Coinbase       bool
+	Amounts        map[string]int
+	Counterparties []string
+}
+
+// HistoryOutput is an output paying a wallet address, SpentBy is the hex-encoded ID of the transaction spending it
+type HistoryOutput struct {
+	TxID    string
+	Address string
+	Value   int
+	SpentBy string
+}
+
+// NewHistory creates History and fills it from a file if it exists
+func NewHistory(nodeID string) (*History, error) {
+	history := History{Outputs: make(map[string]*HistoryOutput), Pending: make(map[string]*PendingTransaction)}
+
+	err := history.LoadFromFile(nodeID)
+
+	return &history, err
+}
+
+// Amount returns the net amount the transaction moved in or out of the wallet
+func (he *HistoryEntry) Amount() int {
+	amount := 0
+
+	for _, value := range he.Amounts {
+		amount += value
+	}
+
+	return amount
+}
+
+// Confirmations returns the number of blocks confirming the transaction
+func (he *HistoryEntry) Confirmations(bestHeight int) int {
+	return bestHeight - he.Height + 1
+}
+
+// Height returns the height of the last block applied to the ledger, -1 when there is none
+func (h *History) Height() int {
+	return len(h.Blocks) - 1
+}
+
+// Sync brings the ledger in line with the main chain
+// Blocks no longer on the main chain are disconnected first, then the missing blocks are connected in order
+// An interrupted rescan is completed along the way
+func (h *History) Sync(bc *blockchain.Blockchain, ws *Wallets) {
+	h.catchUp(bc, ws, nil)
+}
+
+// Rescan rebuilds the ledger from the block at the height with every address currently in the wallet
+// The transactions of earlier blocks are left as they are. A negative height resumes an interrupted rescan
+// checkpoint is called every RescanCheckpointInterval blocks and once the rescan is done, so the progress can be saved
+func (h *History) Rescan(bc *blockchain.Blockchain, ws *Wallets, fromHeight int, checkpoint func(height, bestHeight int)) error {
+	if fromHeight < 0 {
+		if !h.Rescanning {
+			return errNoRescan
+		}
+	} else {
+		if fromHeight > bc.GetBestHeight() {
+			return fmt.Errorf("ERROR: Height %d is above the best height %d", fromHeight, bc.GetBestHeight())
+		}
+
+		h.disconnectBlocks(fromHeight - 1)
+		h.RescanFrom = fromHeight
+		h.Rescanning = true
+	}
+
+	h.catchUp(bc, ws, checkpoint)
+
+	return nil
+}
+
+// catchUp disconnects the blocks that left the main chain and connects the main chain blocks missing from the ledger
+// Blocks below the height a rescan started from are only recorded, their transactions were not asked for
+func (h *History) catchUp(bc *blockchain.Blockchain, ws *Wallets, checkpoint func(height, bestHeight int)) {
+	fork, blocks := h.findFork(bc)
+	h.disconnectBlocks(fork)
+
+	owners := ws.addressesByPubKeyHash()
+	bestHeight := fork + len(blocks)
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		if h.Rescanning && block.Height() < h.RescanFrom {
+			h.Blocks = append(h.Blocks[:block.Height()], block.Hash())
+		} else {
+			h.connectBlock(block, owners)
+		}
+
+		if checkpoint != nil && i > 0 && (len(blocks)-i)%RescanCheckpointInterval == 0 {
+			checkpoint(block.Height(), bestHeight)
+		}
+	}
+
+	h.Rescanning = false
+
+	if checkpoint != nil {
+		checkpoint(h.Height(), bestHeight)
+	}
+}
+
+// findFork returns the height of the last ledger block still on the main chain and the main chain blocks above it, newest first
+func (h *History) findFork(bc *blockchain.Blockchain) (int, []*blockchain.Block) {
+	var blocks []*blockchain.Block
+
+	iterator := bc.Iterator()
+
+	for {
+		block := iterator.Next()
+
+		if block.Height() <= h.Height() && bytes.Equal(h.Blocks[block.Height()], block.Hash()) {
+			return block.Height(), blocks
+		}
+
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash()) == 0 {
+			return -1, blocks
+		}
+	}
+}
+
+// Transactions returns the most recent transactions first
+// An empty address returns the transactions of the whole wallet, a limit of zero returns all of them
+// Entries are kept under the Base58Check address, so a Bech32 address is converted first
+func (h *History) Transactions(address string, limit int) []*HistoryEntry {
+	var entries []*HistoryEntry
+
+	if pubKeyHash, err := utils.DecodeAddress(address); err == nil {
+		address = utils.EncodeAddress(pubKeyHash)
+	}
+
+	for i := len(h.Entries) - 1; i >= 0; i-- {
+		entry := h.Entries[i]
+
+		if address != "" {
+			if _, ok := entry.Amounts[address]; !ok {
+				continue
+			}
+		}
+
+		entries = append(entries, entry)
+
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+
+	return entries
+}
+
+// LoadFromFile loads the ledger from the file
+func (h *History) LoadFromFile(nodeID string) error {
+	historyFile := utils.DataPath(fmt.Sprintf(historyFile, nodeID))
+
+	fileContent, err := os.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var history History
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&history)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	h.Blocks = history.Blocks
+	h.Entries = history.Entries
+	h.RescanFrom = history.RescanFrom
+	h.Rescanning = history.Rescanning
+
+	if history.Outputs != nil {
+		h.Outputs = history.Outputs
+	}
+
+	if history.Pending != nil {
+		h.Pending = history.Pending
+	}
+
+	return nil
+}
+
+// SaveToFile saves the ledger to a file
+func (h *History) SaveToFile(nodeID string) {
+	var content bytes.Buffer
+	historyFile := utils.DataPath(fmt.Sprintf(historyFile, nodeID))
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(h)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(historyFile, content.Bytes(), walletFileMode)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// connectBlock records the transactions of the block touching the wallet
+// owners maps the hex-encoded public key hash of every wallet address to the address
+func (h *History) connectBlock(block *blockchain.Block, owners map[string]string) {
+	h.confirmPending(block)
+
+	for _, tx := range block.Transactions() {
+		txID := hex.EncodeToString(tx.ID())
+		amounts := make(map[string]int)
+		counterparties := make(map[string]bool)
+
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin() {
+				output, ok := h.Outputs[outpoint(vin.TxId(), vin.Vout())]
+				if !ok {
+					counterparties[string(encodeAddress(utils.HashPubKey(vin.PubKey())))] = true
+					continue
+				}
+
+				output.SpentBy = txID
+				amounts[output.Address] -= output.Value
+			}
+		}
+
+		for outIdx, out := range tx.Vout() {
+			address, ok := owners[hex.EncodeToString(out.PubKeyHash())]
+			if !ok {
+				counterparties[string(encodeAddress(out.PubKeyHash()))] = true
+				continue
+			}
+
+			h.Outputs[outpoint(tx.ID(), outIdx)] = &HistoryOutput{TxID: txID, Address: address, Value: out.Value()}
+			amounts[address] += out.Value()
+		}
+
+		if len(amounts) == 0 {
+			continue
+		}
+
+		entry := &HistoryEntry{
+			TxID:      tx.ID(),
+			BlockHash: block.Hash(),
+			Height:    block.Height(),
+			Timestamp: block.Timestamp(),
+			Coinbase:  tx.IsCoinbase(),
+			Amounts:   amounts,
+		}
+
+		for address := range counterparties {
+			entry.Counterparties = append(entry.Counterparties, address)
+		}
+		sort.Strings(entry.Counterparties)
+
+		h.Entries = append(h.Entries, entry)
+	}
+
+	h.Blocks = append(h.Blocks[:block.Height()], block.Hash())
+}
+
+// disconnectBlocks undoes every block above the height, restoring the outputs their transactions spent
+func (h *History) disconnectBlocks(height int) {
+	if height >= h.Height() {
+		return
+	}
+
+	removed := make(map[string]bool)
+	kept := h.Entries[:0]
+
+	for _, entry := range h.Entries {
+		if entry.Height > height {
+			removed[hex.EncodeToString(entry.TxID)] = true
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+
+	for key, output := range h.Outputs {
+		if removed[output.TxID] {
+			delete(h.Outputs, key)
+		} else if removed[output.SpentBy] {
+			output.SpentBy = ""
+		}
+	}
+
+	h.Entries = kept
+	h.Blocks = h.Blocks[:height+1]
+}
+
+// addressesByPubKeyHash maps the hex-encoded public key hash of every wallet address to the address
+func (ws *Wallets) addressesByPubKeyHash() map[string]string {
+	owners := make(map[string]string)
+
+	for address, wallet := range ws.Wallets {
+		owners[hex.EncodeToString(wallet.GetPubKeyHash())] = address
+	}
+
+	return owners
+}
+
+// outpoint returns the key identifying an output of a transaction
+func outpoint(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+const (
+	lightChainFile = "headers_%s.dat"
+
+	// lightFilterFPRate is the false positive rate of the bloom filter of a light wallet
+	// A higher rate hides better which transactions are the wallet's, at the cost of downloading more of them
+	lightFilterFPRate = 0.0001
+)
+
+var (
+	errHeaderNotConnected = errors.New("ERROR: The headers don't connect to the header chain")
+	errBadMerkleBlock     = errors.New("ERROR: The filtered block does not prove its transactions")
+)
+
+// LightChain is the header chain of a light wallet, with the transactions of its addresses full nodes proved to be in its blocks
+// Headers holds the headers of the main chain by height from the genesis block, the first Scanned of them were scanned
+// for the transactions of the hex-encoded public key hashes of Watched
+type LightChain struct {
+	Headers      []*blockchain.BlockHeader
+	Scanned      int
+	Watched      map[string]bool
+	Transactions []*LightTransaction
+}
+
+// LightTransaction is a transaction of the wallet and the block it was proved to be in
+type LightTransaction struct {
+	Transaction *transaction.Transaction
+	BlockHash   []byte
+	Height      int
+}
+
+// NewLightChain creates LightChain and fills it from a file if it exists, a new one starts at the genesis block
+func NewLightChain(nodeID string) (*LightChain, error) {
+	lc := LightChain{Watched: make(map[string]bool)}
+
+	err := lc.LoadFromFile(nodeID)
+	if err != nil {
+		return &lc, err
+	}
+
+	if len(lc.Headers) == 0 {
+		genesis := blockchain.NewGenesisBlock()
+		if !genesis.IsGenesis() {
+			log.Panicf("ERROR: Genesis block %x does not have the hash set in the chain parameters", genesis.Hash())
+		}
+
+		lc.Headers = []*blockchain.BlockHeader{genesis.Header()}
+	}
+
+	return &lc, nil
+}
+
+// Height returns the height of the highest header
+func (lc *LightChain) Height() int {
+	return len(lc.Headers) - 1
+}
+
+// Watch adds the public key hash to the ones the blocks are scanned for
+// The blocks scanned before are scanned again for a new one
+func (lc *LightChain) Watch(pubKeyHash []byte) {
+	key := hex.EncodeToString(pubKeyHash)
+	if lc.Watched[key] {
+		return
+	}
+
+	lc.Watched[key] = true
+	lc.Scanned = 0
+}
+
+// Filter returns a bloom filter matching the transactions of the watched public key hashes, with a fresh tweak
+func (lc *LightChain) Filter() *utils.BloomFilter {
+	filter := utils.NewBloomFilter(len(lc.Watched), lightFilterFPRate, rand.Uint32())
+
+	for key := range lc.Watched {
+		pubKeyHash, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		filter.Add(pubKeyHash)
+	}
+
+	return filter
+}
+
+// Locator returns the block locator of the highest header
+func (lc *LightChain) Locator() [][]byte {
+	var locator [][]byte
+
+	for _, height := range blockchain.LocatorHeights(lc.Height()) {
+		locator = append(locator, lc.Headers[height].Hash())
+	}
+
+	return locator
+}
+
+// AddHeaders adds headers following a header of the chain, each with a valid proof of work
+// Headers forking from the chain replace its tip when they make it longer, the transactions of the blocks they replace are dropped
+func (lc *LightChain) AddHeaders(headers []*blockchain.BlockHeader) error {
+	for len(headers) > 0 && lc.hasHeader(headers[0]) {
+		headers = headers[1:]
+	}
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	fork := headers[0].Height() - 1
+	if fork < 0 || fork > lc.Height() || !bytes.Equal(lc.Headers[fork].Hash(), headers[0].PrevBlockHash()) {
+		return errHeaderNotConnected
+	}
+
+	prev := lc.Headers[fork]
+
+	for _, h := range headers {
+		if h.Height() != prev.Height()+1 || !bytes.Equal(h.PrevBlockHash(), prev.Hash()) {
+			return errHeaderNotConnected
+		}
+
+		if !blockchain.NewHeaderProofOfWork(h).Validate() {
+			return fmt.Errorf("ERROR: Header %x has an invalid proof of work", h.Hash())
+		}
+
+		prev = h
+	}
+
+	if prev.Height() <= lc.Height() {
+		return nil
+	}
+
+	lc.Headers = append(lc.Headers[:fork+1], headers...)
+	lc.Scanned = min(lc.Scanned, fork+1)
+
+	var kept []*LightTransaction
+	for _, ltx := range lc.Transactions {
+		if ltx.Height <= fork {
+			kept = append(kept, ltx)
+		}
+	}
+
+	lc.Transactions = kept
+
+	return nil
+}
+
+// hasHeader tells whether the header is the one of the chain at its height
+func (lc *LightChain) hasHeader(h *blockchain.BlockHeader) bool {
+	height := h.Height()
+
+	return height >= 0 && height <= lc.Height() && bytes.Equal(lc.Headers[height].Hash(), h.Hash())
+}
+
+// UnscannedBlocks returns the hashes of the blocks not scanned yet, lowest first
+func (lc *LightChain) UnscannedBlocks() [][]byte {
+	var hashes [][]byte
+
+	for _, h := range lc.Headers[lc.Scanned:] {
+		hashes = append(hashes, h.Hash())
+	}
+
+	return hashes
+}
+
+// AddMerkleBlock scans the next unscanned block with the transactions a full node says match the filter
+// The partial Merkle tree has to lead to the merkle root of the header of the chain and prove every transaction,
+// only the transactions of the watched public key hashes are kept, the others are false positives of the filter
+func (lc *LightChain) AddMerkleBlock(header *blockchain.BlockHeader, tree *utils.PartialMerkleTree, txs []*transaction.Transaction) error {
+	if header == nil || tree == nil || lc.Scanned > lc.Height() {
+		return errBadMerkleBlock
+	}
+
+	ours := lc.Headers[lc.Scanned]
+	if !bytes.Equal(header.Hash(), ours.Hash()) {
+		return fmt.Errorf("ERROR: Filtered block %x is not the next block to scan, %x", header.Hash(), ours.Hash())
+	}
+
+	root, matches, _, err := tree.ExtractMatches()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(root, ours.MerkleRoot()) || len(matches) != len(txs) {
+		return errBadMerkleBlock
+	}
+
+	proved := make(map[string]bool)
+	for _, leaf := range matches {
+		proved[hex.EncodeToString(leaf)] = true
+	}
+
+	for _, tx := range txs {
+		if !proved[hex.EncodeToString(utils.MerkleLeaf(tx.Bytes()))] {
+			return errBadMerkleBlock
+		}
+	}
+
+	for _, tx := range txs {
+		if lc.touches(tx) && !lc.hasTransaction(tx.ID(), ours.Hash()) {
+			lc.Transactions = append(lc.Transactions, &LightTransaction{tx, ours.Hash(), ours.Height()})
+		}
+	}
+
+	lc.Scanned++
+
+	return nil
+}
+
+// Balance returns the sum of the outputs paying the public key hash no transaction of the chain spends
+func (lc *LightChain) Balance(pubKeyHash []byte) int {
+	spent := make(map[string]bool)
+
+	for _, ltx := range lc.Transactions {
+		if ltx.Transaction.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range ltx.Transaction.Vin() {
+			spent[outpoint(vin.TxId(), vin.Vout())] = true
+		}
+	}
+
+	balance := 0
+
+	for _, ltx := range lc.Transactions {
+		for index, out := range ltx.Transaction.Vout() {
+			if out.IsLockedWithKey(pubKeyHash) && !spent[outpoint(ltx.Transaction.ID(), index)] {
+				balance += out.Value()
+			}
+		}
+	}
+
+	return balance
+}
+
+// touches tells whether the transaction pays or spends from a watched public key hash
+func (lc *LightChain) touches(tx *transaction.Transaction) bool {
+	for _, out := range tx.Vout() {
+		if lc.Watched[hex.EncodeToString(out.PubKeyHash())] {
+			return true
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return false
+	}
+
+	for _, vin := range tx.Vin() {
+		if lc.Watched[hex.EncodeToString(utils.HashPubKey(vin.PubKey()))] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasTransaction tells whether the transaction was already kept for the block, a rescan finds it again
+func (lc *LightChain) hasTransaction(txID, blockHash []byte) bool {
+	for _, ltx := range lc.Transactions {
+		if bytes.Equal(ltx.Transaction.ID(), txID) && bytes.Equal(ltx.BlockHash, blockHash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadFromFile loads the light chain from the file
+func (lc *LightChain) LoadFromFile(nodeID string) error {
+	lightChainFile := utils.DataPath(fmt.Sprintf(lightChainFile, nodeID))
+
+	fileContent, err := os.ReadFile(lightChainFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var chain LightChain
+	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
+	err = decoder.Decode(&chain)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	lc.Headers = chain.Headers
+	lc.Scanned = chain.Scanned
+	lc.Transactions = chain.Transactions
+
+	if chain.Watched != nil {
+		lc.Watched = chain.Watched
+	}
+
+	return nil
+}
+
+// SaveToFile saves the light chain to a file
+func (lc *LightChain) SaveToFile(nodeID string) {
+	var content bytes.Buffer
+	lightChainFile := utils.DataPath(fmt.Sprintf(lightChainFile, nodeID))
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(lc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(lightChainFile, content.Bytes(), walletFileMode)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,119 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	mnemonicEntropyBits = 128
+	mnemonicIterations  = 2048
+	bitsPerWord         = 11
+)
+
+//go:embed wordlist/english.txt
+var englishWordList string
+
+var (
+	wordList  = strings.Fields(englishWordList)
+	wordIndex = indexWords(wordList)
+
+	errMnemonicChecksum = errors.New("ERROR: Mnemonic checksum is invalid")
+)
+
+// NewMnemonic generates a random BIP39 mnemonic phrase
+func NewMnemonic() (string, []byte, error) {
+	entropy := make([]byte, mnemonicEntropyBits/8)
+
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mnemonic, err := EntropyToMnemonic(entropy)
+
+	return mnemonic, entropy, err
+}
+
+// EntropyToMnemonic encodes entropy as a BIP39 mnemonic phrase
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	entropyBits := len(entropy) * 8
+	if entropyBits < 128 || entropyBits > 256 || entropyBits%32 != 0 {
+		return "", fmt.Errorf("ERROR: Entropy of %d bits can't be encoded as a mnemonic", entropyBits)
+	}
+
+	checksumBits := entropyBits / 32
+	checksum := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	words := make([]string, (entropyBits+checksumBits)/bitsPerWord)
+	mask := big.NewInt(1<<bitsPerWord - 1)
+
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, bitsPerWord)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a BIP39 mnemonic phrase and verifies its checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("ERROR: A mnemonic has 12, 15, 18, 21 or 24 words, got %d", len(words))
+	}
+
+	data := new(big.Int)
+
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("ERROR: %q is not a mnemonic word", word)
+		}
+
+		data.Lsh(data, bitsPerWord)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * bitsPerWord / 33
+	entropyBits := checksumBits * 32
+
+	checksum := new(big.Int).And(data, big.NewInt(int64(1<<checksumBits-1)))
+	entropy := data.Rsh(data, uint(checksumBits)).FillBytes(make([]byte, entropyBits/8))
+
+	expected := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(expected[0]>>(8-checksumBits)) {
+		return nil, errMnemonicChecksum
+	}
+
+	return entropy, nil
+}
+
+// MnemonicToSeed stretches a mnemonic phrase and its optional passphrase into a 64-byte seed
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), mnemonicIterations, 64, sha512.New)
+}
+
+func indexWords(words []string) map[string]int {
+	index := make(map[string]int)
+
+	for i, word := range words {
+		index[word] = i
+	}
+
+	return index
+}
@@ -0,0 +1,205 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+)
+
+// PendingTransaction is a transaction the wallet sent that no block confirmed yet
+// The outputs it spends stay locked so they are not spent twice
+type PendingTransaction struct {
+	Transaction []byte
+	Timestamp   int64
+}
+
+// AddPending records a transaction sent without being mined
+func (h *History) AddPending(tx *transaction.Transaction) {
+	h.Pending[hex.EncodeToString(tx.ID())] = &PendingTransaction{tx.Serialize(), time.Now().Unix()}
+}
+
+// AbandonPending forgets a pending transaction that will never confirm, unlocking the outputs it spends
+// Pending transactions spending its outputs are abandoned too
+func (h *History) AbandonPending(txID string) error {
+	if _, ok := h.Pending[txID]; !ok {
+		return fmt.Errorf("ERROR: Transaction %s is not pending", txID)
+	}
+
+	h.removePending(txID)
+
+	return nil
+}
+
+// PendingTransactions returns the pending transactions indexed by hex-encoded ID
+func (h *History) PendingTransactions() map[string]transaction.Transaction {
+	pending := make(map[string]transaction.Transaction)
+
+	for txID, pendingTx := range h.Pending {
+		pending[txID] = transaction.DeserializeTransaction(pendingTx.Transaction)
+	}
+
+	return pending
+}
+
+// SpendableOutputs returns the confirmed outputs locked with the public key hash that no pending transaction spends
+// With allowUnconfirmed the unspent outputs of pending transactions, such as their change, are included as well
+func (h *History) SpendableOutputs(UTXOSet *chainstate.UTXOSet, pubKeyHash []byte, allowUnconfirmed bool) []chainstate.UnspentOutput {
+	var spendable []chainstate.UnspentOutput
+
+	pending := h.PendingTransactions()
+	locked := lockedOutputs(pending)
+
+	for _, utxo := range UTXOSet.FindUnspentOutputs(pubKeyHash) {
+		if !locked[outpoint(utxo.TxId(), utxo.Index())] {
+			spendable = append(spendable, utxo)
+		}
+	}
+
+	if allowUnconfirmed {
+		spendable = append(spendable, pendingOutputs(pending, locked, pubKeyHash)...)
+	}
+
+	return spendable
+}
+
+// PendingBalance returns how much the pending transactions change the balance of the public key hash
+func (h *History) PendingBalance(UTXOSet *chainstate.UTXOSet, pubKeyHash []byte) int {
+	balance := 0
+
+	pending := h.PendingTransactions()
+	locked := lockedOutputs(pending)
+
+	for _, utxo := range UTXOSet.FindUnspentOutputs(pubKeyHash) {
+		if locked[outpoint(utxo.TxId(), utxo.Index())] {
+			balance -= utxo.Value()
+		}
+	}
+
+	for _, utxo := range pendingOutputs(pending, locked, pubKeyHash) {
+		balance += utxo.Value()
+	}
+
+	return balance
+}
+
+// prevTransactions returns the transactions referenced by the inputs, looking at pending transactions before the chain
+func (h *History) prevTransactions(bc *blockchain.Blockchain, tx *transaction.Transaction) map[string]transaction.Transaction {
+	prevTxs := make(map[string]transaction.Transaction)
+	pending := h.PendingTransactions()
+
+	for _, vin := range tx.Vin() {
+		txID := hex.EncodeToString(vin.TxId())
+
+		if prevTx, ok := pending[txID]; ok {
+			prevTxs[txID] = prevTx
+			continue
+		}
+
+		prevTx, err := bc.FindTransaction(vin.TxId())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		prevTxs[txID] = prevTx
+	}
+
+	return prevTxs
+}
+
+// confirmPending drops the pending transactions the block confirms and the ones it conflicts with
+func (h *History) confirmPending(block *blockchain.Block) {
+	if len(h.Pending) == 0 {
+		return
+	}
+
+	pending := h.PendingTransactions()
+	locked := lockedOutputs(pending)
+
+	for _, tx := range block.Transactions() {
+		txID := hex.EncodeToString(tx.ID())
+
+		if _, ok := pending[txID]; ok {
+			delete(h.Pending, txID)
+			continue
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		// Another transaction spent the same output, so the pending one can never confirm
+		for _, vin := range tx.Vin() {
+			if locked[outpoint(vin.TxId(), vin.Vout())] {
+				for pendingID, pendingTx := range pending {
+					if spendsOutput(&pendingTx, vin.TxId(), vin.Vout()) {
+						h.removePending(pendingID)
+					}
+				}
+			}
+		}
+	}
+}
+
+// removePending drops a pending transaction and every pending transaction spending its outputs
+func (h *History) removePending(txID string) {
+	delete(h.Pending, txID)
+
+	id, err := hex.DecodeString(txID)
+	if err != nil {
+		return
+	}
+
+	for childID, child := range h.PendingTransactions() {
+		for _, vin := range child.Vin() {
+			if bytes.Equal(vin.TxId(), id) {
+				h.removePending(childID)
+				break
+			}
+		}
+	}
+}
+
+// lockedOutputs returns the outpoints spent by pending transactions
+func lockedOutputs(pending map[string]transaction.Transaction) map[string]bool {
+	locked := make(map[string]bool)
+
+	for _, tx := range pending {
+		for _, vin := range tx.Vin() {
+			locked[outpoint(vin.TxId(), vin.Vout())] = true
+		}
+	}
+
+	return locked
+}
+
+// pendingOutputs returns the outputs of pending transactions locked with the public key hash and not spent yet
+func pendingOutputs(pending map[string]transaction.Transaction, locked map[string]bool, pubKeyHash []byte) []chainstate.UnspentOutput {
+	var outputs []chainstate.UnspentOutput
+
+	for _, tx := range pending {
+		for outIdx, out := range tx.Vout() {
+			if out.IsLockedWithKey(pubKeyHash) && !locked[outpoint(tx.ID(), outIdx)] {
+				outputs = append(outputs, *chainstate.NewUnspentOutput(tx.ID(), outIdx, out))
+			}
+		}
+	}
+
+	return outputs
+}
+
+// spendsOutput tells whether the transaction spends the output
+func spendsOutput(tx *transaction.Transaction, txID []byte, index int) bool {
+	for _, vin := range tx.Vin() {
+		if bytes.Equal(vin.TxId(), txID) && vin.Vout() == index {
+			return true
+		}
+	}
+
+	return false
+}
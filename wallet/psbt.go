@@ -0,0 +1,280 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+)
+
+var (
+	errNotFullySigned = errors.New("ERROR: Transaction is not signed by every input owner")
+	errBadSignature   = errors.New("ERROR: Transaction signatures are not valid")
+	errNothingToSign  = errors.New("ERROR: The wallet holds no key for any input of the transaction")
+)
+
+// PartiallySignedTransaction is a transaction passed between machines while its inputs are signed
+// PrevTransactions carries every transaction the inputs spend from, so it can be signed and checked without a Blockchain
+type PartiallySignedTransaction struct {
+	Transaction      []byte
+	PrevTransactions map[string][]byte
+}
+
+// NewPartiallySignedTransaction wraps the transaction together with the transactions its inputs spend from
+func NewPartiallySignedTransaction(tx *transaction.Transaction, prevTxs map[string]transaction.Transaction) *PartiallySignedTransaction {
+	prevTransactions := make(map[string][]byte)
+
+	for txID, prevTx := range prevTxs {
+		prevTransactions[txID] = prevTx.Serialize()
+	}
+
+	return &PartiallySignedTransaction{tx.Serialize(), prevTransactions}
+}
+
+// CreatePartiallySignedTransaction builds an unsigned transaction paying every recipient from outputs of the addresses
+// Watch-only addresses can fund it since no key is needed, the change goes back to the first address
+func (ws *Wallets) CreatePartiallySignedTransaction(from []string, recipients []Recipient, feeRate int, selector CoinSelector, UTXOSet *chainstate.UTXOSet, history *History, allowUnconfirmed bool) (*PartiallySignedTransaction, *CoinSelection) {
+	var owners []*Wallet
+
+	for _, address := range from {
+		wallet, ok := ws.findWallet(address)
+		if !ok {
+			log.Panicf("ERROR: Address %s is not in the wallet", address)
+		}
+
+		owners = append(owners, wallet)
+	}
+
+	changeAddress := func() string {
+		return from[0]
+	}
+
+	tx, selection := fundTransaction(owners, recipients, feeRate, selector, changeAddress, UTXOSet, history, allowUnconfirmed)
+	prevTxs := prevTransactions(UTXOSet.Blockchain(), history, tx)
+
+	return NewPartiallySignedTransaction(tx, prevTxs), selection
+}
+
+// LoadPartiallySignedTransaction reads a partially signed transaction from a file
+func LoadPartiallySignedTransaction(path string) (*PartiallySignedTransaction, error) {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pst PartiallySignedTransaction
+	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&pst)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s is not a partially signed transaction: %w", path, err)
+	}
+
+	err = pst.check()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pst, nil
+}
+
+// check makes sure every input spends an existing output of a previous transaction matching the ID the input refers to
+// A file from elsewhere could otherwise make the outputs spent look like they hold other amounts or belong to other addresses
+func (pst *PartiallySignedTransaction) check() error {
+	tx, err := transaction.DecodeTransaction(pst.Transaction)
+	if err != nil {
+		return fmt.Errorf("ERROR: The transaction can't be decoded: %w", err)
+	}
+
+	for _, vin := range tx.Vin() {
+		data, ok := pst.PrevTransactions[hex.EncodeToString(vin.TxId())]
+		if !ok {
+			return fmt.Errorf("ERROR: Previous transaction %x is missing", vin.TxId())
+		}
+
+		prevTx, err := transaction.DecodeTransaction(data)
+		if err != nil {
+			return fmt.Errorf("ERROR: Previous transaction %x can't be decoded: %w", vin.TxId(), err)
+		}
+
+		if !bytes.Equal(prevTx.UnsignedHash(), vin.TxId()) {
+			return fmt.Errorf("ERROR: Previous transaction %x does not match its ID", vin.TxId())
+		}
+
+		if vin.Vout() < 0 || vin.Vout() >= len(prevTx.Vout()) {
+			return fmt.Errorf("ERROR: Input %x:%d spends an output that does not exist", vin.TxId(), vin.Vout())
+		}
+	}
+
+	return nil
+}
+
+// SaveToFile writes the partially signed transaction to a file
+func (pst *PartiallySignedTransaction) SaveToFile(path string) {
+	var content bytes.Buffer
+
+	err := gob.NewEncoder(&content).Encode(pst)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(path, content.Bytes(), walletFileMode)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// Tx returns the transaction with the signatures collected so far
+func (pst *PartiallySignedTransaction) Tx() transaction.Transaction {
+	return transaction.DeserializeTransaction(pst.Transaction)
+}
+
+// PrevTxs returns the transactions the inputs spend from, indexed by hex-encoded ID
+func (pst *PartiallySignedTransaction) PrevTxs() map[string]transaction.Transaction {
+	prevTxs := make(map[string]transaction.Transaction)
+
+	for txID, prevTx := range pst.PrevTransactions {
+		prevTxs[txID] = transaction.DeserializeTransaction(prevTx)
+	}
+
+	return prevTxs
+}
+
+// Fee returns what the inputs spend beyond the outputs
+func (pst *PartiallySignedTransaction) Fee() (int, error) {
+	err := pst.check()
+	if err != nil {
+		return 0, err
+	}
+
+	tx := pst.Tx()
+	prevTxs := pst.PrevTxs()
+	fee := 0
+
+	for _, vin := range tx.Vin() {
+		prevTx := prevTxs[hex.EncodeToString(vin.TxId())]
+		fee += prevTx.Vout()[vin.Vout()].Value()
+	}
+
+	for _, out := range tx.Vout() {
+		fee -= out.Value()
+	}
+
+	return fee, nil
+}
+
+// String returns a human-readable summary to check before signing or broadcasting
+// A transaction spending outputs it does not carry is described by the error instead
+func (pst *PartiallySignedTransaction) String() string {
+	var lines []string
+
+	fee, err := pst.Fee()
+	if err != nil {
+		return err.Error()
+	}
+
+	tx := pst.Tx()
+	prevTxs := pst.PrevTxs()
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID()))
+
+	for _, vin := range tx.Vin() {
+		prevTx := prevTxs[hex.EncodeToString(vin.TxId())]
+		prevOut := prevTx.Vout()[vin.Vout()]
+		signed := "unsigned"
+		if vin.Signature() != nil {
+			signed = "signed"
+		}
+
+		lines = append(lines, fmt.Sprintf("  Input  %x:%d  %s  %d  %s", vin.TxId(), vin.Vout(), encodeAddress(prevOut.PubKeyHash()), prevOut.Value(), signed))
+	}
+
+	for _, out := range tx.Vout() {
+		lines = append(lines, fmt.Sprintf("  Output %s  %d", encodeAddress(out.PubKeyHash()), out.Value()))
+	}
+
+	lines = append(lines, fmt.Sprintf("  Fee    %d", fee))
+
+	return strings.Join(lines, "\n")
+}
+
+// Sign signs every input spending an output of a wallet address holding a key and returns how many it signed
+// Inputs of watch-only addresses get their public key first, which changes the ID, so they are signed before any other
+func (ws *Wallets) Sign(pst *PartiallySignedTransaction) (int, error) {
+	err := pst.check()
+	if err != nil {
+		return 0, err
+	}
+
+	privateKeys := make(map[string]ecdsa.PrivateKey)
+	ws.lockIfExpired()
+
+	for _, wallet := range ws.Wallets {
+		if wallet.IsWatchOnly() {
+			continue
+		}
+
+		if wallet.PrivateKey.D == nil {
+			return 0, errWalletLocked
+		}
+
+		privateKeys[hex.EncodeToString(wallet.GetPubKeyHash())] = wallet.PrivateKey
+	}
+
+	tx := pst.Tx()
+	signed, err := tx.SignPartial(privateKeys, pst.PrevTxs())
+	if err != nil {
+		return 0, err
+	}
+
+	if signed == 0 {
+		return 0, errNothingToSign
+	}
+
+	pst.Transaction = tx.Serialize()
+
+	return signed, nil
+}
+
+// Combine merges the signatures of copies of the same transaction signed separately
+func (pst *PartiallySignedTransaction) Combine(others ...*PartiallySignedTransaction) error {
+	tx := pst.Tx()
+
+	for _, other := range others {
+		err := tx.Combine(other.Tx())
+		if err != nil {
+			return err
+		}
+
+		for txID, prevTx := range other.PrevTransactions {
+			if _, ok := pst.PrevTransactions[txID]; !ok {
+				pst.PrevTransactions[txID] = prevTx
+			}
+		}
+	}
+
+	pst.Transaction = tx.Serialize()
+
+	return nil
+}
+
+// Finalize returns the transaction once every input is signed and every signature is valid
+func (pst *PartiallySignedTransaction) Finalize() (*transaction.Transaction, error) {
+	tx := pst.Tx()
+
+	if !tx.IsSigned() {
+		return nil, errNotFullySigned
+	}
+
+	if !tx.Verify(pst.PrevTxs()) {
+		return nil, errBadSignature
+	}
+
+	return &tx, nil
+}
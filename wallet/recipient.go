@@ -0,0 +1,151 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+// Recipient is a destination address and the amount it receives
+type Recipient struct {
+	address string
+	amount  int
+}
+
+// recipientEntry is the JSON representation of a Recipient
+type recipientEntry struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// NewRecipient creates a Recipient after validating the address and the amount
+func NewRecipient(address string, amount int) (*Recipient, error) {
+	_, err := utils.DecodeAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w (recipient %s)", err, address)
+	}
+
+	if amount <= 0 {
+		return nil, fmt.Errorf("ERROR: Amount for %s must be positive", address)
+	}
+
+	return &Recipient{address, amount}, nil
+}
+
+// ParseRecipient parses a recipient given as ADDRESS:AMOUNT
+func ParseRecipient(value string) (*Recipient, error) {
+	address, amount, found := strings.Cut(value, ":")
+	if !found {
+		return nil, fmt.Errorf("ERROR: Recipient %q must be ADDRESS:AMOUNT", value)
+	}
+
+	return parseRecipient(address, amount)
+}
+
+// LoadRecipients reads recipients from a JSON or CSV file
+// JSON files hold an array of {"address", "amount"} objects, CSV files hold address,amount rows
+func LoadRecipients(path string) ([]Recipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return readJSONRecipients(file)
+	}
+
+	return readCSVRecipients(file)
+}
+
+func (r *Recipient) Address() string {
+	return r.address
+}
+
+func (r *Recipient) Amount() int {
+	return r.amount
+}
+
+// TotalAmount sums the amounts paid to the recipients
+func TotalAmount(recipients []Recipient) int {
+	total := 0
+
+	for _, recipient := range recipients {
+		total += recipient.Amount()
+	}
+
+	return total
+}
+
+func parseRecipient(address, amount string) (*Recipient, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(amount))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Invalid amount %q for %s", amount, address)
+	}
+
+	return NewRecipient(strings.TrimSpace(address), value)
+}
+
+func readJSONRecipients(r io.Reader) ([]Recipient, error) {
+	var entries []recipientEntry
+	var recipients []Recipient
+
+	err := json.NewDecoder(r).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		recipient, err := NewRecipient(entry.Address, entry.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, *recipient)
+	}
+
+	return recipients, nil
+}
+
+func readCSVRecipients(r io.Reader) ([]Recipient, error) {
+	var recipients []Recipient
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// An optional header row names the columns
+		if line == 1 && strings.EqualFold(record[0], "address") {
+			continue
+		}
+
+		recipient, err := parseRecipient(record[0], record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		recipients = append(recipients, *recipient)
+	}
+
+	return recipients, nil
+}
@@ -1,31 +1,96 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
 
+	"github.com/lugassawan/learning-golang-blockchain/blockchain"
 	"github.com/lugassawan/learning-golang-blockchain/chainstate"
 	"github.com/lugassawan/learning-golang-blockchain/transaction"
 	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
 
-const version = byte(0x00)
-
 // Wallet stores private and public keys
+// EncryptedKey holds the sealed private key of an encrypted wallet file
+// Path is the derivation path of a key derived from the HD seed
+// A watch-only wallet has no keys, only the PubKeyHash its outputs are locked with
 type Wallet struct {
-	PrivateKey ecdsa.PrivateKey
-	PublicKey  []byte
+	PrivateKey   ecdsa.PrivateKey
+	PublicKey    []byte
+	EncryptedKey []byte
+	Path         string
+	PubKeyHash   []byte
 }
 
 // NewWallet creates and returns a Wallet
 func NewWallet() *Wallet {
 	private, public := utils.NewKeyPair()
-	return &Wallet{private, public}
+	return &Wallet{private, public, nil, "", nil}
+}
+
+// NewWatchOnlyWallet creates a Wallet watching the outputs locked with the public key hash
+func NewWatchOnlyWallet(pubKeyHash []byte) *Wallet {
+	return &Wallet{PubKeyHash: pubKeyHash}
+}
+
+// walletData is the form a Wallet takes in the wallet file
+// Private keys are stored as their scalar, the curve is always P-256
+type walletData struct {
+	PrivateKey   []byte
+	PublicKey    []byte
+	EncryptedKey []byte
+	Path         string
+	PubKeyHash   []byte
+}
+
+// GobEncode encodes the Wallet for the wallet file
+func (w *Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+
+	data := walletData{PublicKey: w.PublicKey, EncryptedKey: w.EncryptedKey, Path: w.Path, PubKeyHash: w.PubKeyHash}
+	if w.PrivateKey.D != nil {
+		data.PrivateKey = utils.PrivateKeyToBytes(w.PrivateKey)
+	}
+
+	err := gob.NewEncoder(&content).Encode(data)
+
+	return content.Bytes(), err
+}
+
+// GobDecode decodes a Wallet read from the wallet file
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data)
+	if err != nil {
+		return err
+	}
+
+	w.PublicKey = data.PublicKey
+	w.EncryptedKey = data.EncryptedKey
+	w.Path = data.Path
+	w.PubKeyHash = data.PubKeyHash
+
+	if data.PrivateKey != nil {
+		w.PrivateKey, err = utils.PrivateKeyFromBytes(data.PrivateKey)
+	}
+
+	return err
 }
 
 func (w *Wallet) GetPrivateKey() ecdsa.PrivateKey {
+	if w.IsWatchOnly() {
+		log.Panicf("ERROR: Address %s is watch-only and can't sign", w.GetAddress())
+	}
+
+	if w.PrivateKey.D == nil {
+		log.Panic(errWalletLocked)
+	}
+
 	return w.PrivateKey
 }
 
@@ -33,52 +98,131 @@ func (w *Wallet) GetPublicKey() []byte {
 	return w.PublicKey
 }
 
+// GetPubKeyHash returns the hash outputs paying this wallet are locked with
+func (w *Wallet) GetPubKeyHash() []byte {
+	if w.IsWatchOnly() {
+		return w.PubKeyHash
+	}
+
+	return utils.HashPubKey(w.GetPublicKey())
+}
+
+// IsCompressed tells whether the public key is in the compressed SEC1 format
+func (w *Wallet) IsCompressed() bool {
+	return len(w.PublicKey) == 33
+}
+
+// IsWatchOnly tells whether the wallet only watches an address it holds no key for
+func (w *Wallet) IsWatchOnly() bool {
+	return w.PublicKey == nil
+}
+
 // GetAddress returns wallet address
 func (w *Wallet) GetAddress() []byte {
-	pubKeyHash := utils.HashPubKey(w.GetPublicKey())
+	return encodeAddress(w.GetPubKeyHash())
+}
+
+// GetBech32Address returns the wallet address in the Bech32 format
+func (w *Wallet) GetBech32Address() string {
+	return utils.EncodeBech32Address(w.GetPubKeyHash())
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := utils.Checksum(versionedPayload)
+// encodeAddress returns the address outputs locked with the public key hash are paid to on the active network
+func encodeAddress(pubKeyHash []byte) []byte {
+	return []byte(utils.EncodeAddress(pubKeyHash))
+}
 
-	fullPayload := append(versionedPayload, checksum...)
-	address := utils.Base58Encode(fullPayload)
+// CreateTransaction a new transaction paying every recipient
+// The inputs are picked by the selector and the chosen coins are returned alongside the transaction
+// Outputs spent by pending transactions of the history are skipped, their change is only spent with allowUnconfirmed
+func (w *Wallet) CreateTransaction(recipients []Recipient, feeRate int, selector CoinSelector, UTXOSet *chainstate.UTXOSet, history *History, allowUnconfirmed bool) (*transaction.Transaction, *CoinSelection) {
+	changeAddress := func() string {
+		return fmt.Sprintf("%s", w.GetAddress())
+	}
 
-	return address
+	return buildTransaction([]*Wallet{w}, recipients, feeRate, selector, changeAddress, UTXOSet, history, allowUnconfirmed)
 }
 
-// CreateTransaction a new transaction
-func (w *Wallet) CreateTransaction(to string, amount int, UTXOSet *chainstate.UTXOSet) *transaction.Transaction {
+// buildTransaction funds the recipients with outputs owned by the wallets
+// Every input is signed with the key of the wallet owning the spent output
+// Without a history only confirmed outputs are known
+func buildTransaction(owners []*Wallet, recipients []Recipient, feeRate int, selector CoinSelector, changeAddress func() string, UTXOSet *chainstate.UTXOSet, history *History, allowUnconfirmed bool) (*transaction.Transaction, *CoinSelection) {
+	privateKeys := make(map[string]ecdsa.PrivateKey)
+
+	for _, owner := range owners {
+		privateKeys[hex.EncodeToString(owner.GetPubKeyHash())] = owner.GetPrivateKey()
+	}
+
+	tx, selection := fundTransaction(owners, recipients, feeRate, selector, changeAddress, UTXOSet, history, allowUnconfirmed)
+	tx.SignWithKeys(privateKeys, prevTransactions(UTXOSet.Blockchain(), history, tx))
+
+	return tx, selection
+}
+
+// fundTransaction builds an unsigned transaction paying the recipients with outputs owned by the wallets
+// Inputs spending outputs of a watch-only wallet are left without a public key for the signer to fill in
+func fundTransaction(owners []*Wallet, recipients []Recipient, feeRate int, selector CoinSelector, changeAddress func() string, UTXOSet *chainstate.UTXOSet, history *History, allowUnconfirmed bool) (*transaction.Transaction, *CoinSelection) {
 	var inputs []transaction.TXInput
 	var outputs []transaction.TXOutput
+	var utxos []chainstate.UnspentOutput
 
-	pubKeyHash := utils.HashPubKey(w.GetPublicKey())
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
-
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	if len(recipients) == 0 {
+		log.Panic("ERROR: No recipients")
 	}
 
-	// Build a list of inputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
+	ownerOf := make(map[string]*Wallet)
+
+	for _, owner := range owners {
+		pubKeyHash := owner.GetPubKeyHash()
+		key := hex.EncodeToString(pubKeyHash)
+
+		if _, ok := ownerOf[key]; ok {
+			continue
 		}
 
-		for _, out := range outs {
-			inputs = append(inputs, *transaction.NewTXInput(txID, out, nil, w.GetPublicKey()))
+		ownerOf[key] = owner
+
+		if history != nil {
+			utxos = append(utxos, history.SpendableOutputs(UTXOSet, pubKeyHash, allowUnconfirmed)...)
+		} else {
+			utxos = append(utxos, UTXOSet.FindUnspentOutputs(pubKeyHash)...)
 		}
 	}
 
+	amount := TotalAmount(recipients)
+	selection, err := selector.Select(utxos, amount, len(recipients), feeRate)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Build a list of inputs
+	for _, utxo := range selection.Inputs() {
+		owner := ownerOf[hex.EncodeToString(utxo.PubKeyHash())]
+		inputs = append(inputs, *transaction.NewTXInput(utxo.TxId(), utxo.Index(), nil, owner.GetPublicKey()))
+	}
+
 	// Build a list of outputs
-	from := fmt.Sprintf("%s", w.GetAddress())
-	outputs = append(outputs, *transaction.NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *transaction.NewTXOutput(acc-amount, from)) // a change
+	for _, recipient := range recipients {
+		outputs = append(outputs, *transaction.NewTXOutput(recipient.Amount(), recipient.Address()))
+	}
+	if selection.Change() > 0 {
+		outputs = append(outputs, *transaction.NewTXOutput(selection.Change(), changeAddress())) // a change
 	}
 
-	tx := transaction.BuildTransaction(inputs, outputs)
-	UTXOSet.Blockchain().SignTransaction(tx, w.GetPrivateKey())
+	return transaction.BuildTransaction(inputs, outputs), selection
+}
+
+// prevTransactions returns the transactions referenced by the inputs
+// With a history the pending transactions are looked at before the chain
+func prevTransactions(bc *blockchain.Blockchain, history *History, tx *transaction.Transaction) map[string]transaction.Transaction {
+	if history != nil {
+		return history.prevTransactions(bc, tx)
+	}
+
+	prevTxs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	return tx
+	return prevTxs
 }
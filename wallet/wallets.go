@@ -2,20 +2,38 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
+	"crypto/ecdsa"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/lugassawan/learning-golang-blockchain/chainstate"
+	"github.com/lugassawan/learning-golang-blockchain/transaction"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
 )
 
-const walletFile = "./database/wallet_%s.dat"
+const (
+	walletFile     = "wallet_%s.dat"
+	walletFileMode = 0600
+)
 
 // Wallets stores a collection of wallets
+// When Encryption is set the private keys are only kept in memory while the wallet is unlocked
+// When HDChain is set new addresses are derived from its seed
 type Wallets struct {
-	Wallets map[string]*Wallet
+	Wallets    map[string]*Wallet
+	Encryption *Encryption
+	HDChain    *HDChain
+
+	mu  sync.Mutex
+	key []byte
+	// lockAt is when the unlock timeout elapses, zero while the wallet stays unlocked until Lock
+	lockAt time.Time
 }
 
 // NewWallets creates Wallets and fills it from a file if it exists
@@ -33,14 +51,138 @@ func (ws *Wallets) GetWallets() map[string]*Wallet {
 }
 
 // CreateWallet adds a Wallet to Wallets
+// A hierarchical deterministic wallet derives the next address from its seed instead of a random key
+// An encrypted wallet has to be unlocked so the new key can be sealed
 func (ws *Wallets) CreateWallet() string {
-	wallet := NewWallet()
+	if ws.IsHD() {
+		address, err := ws.deriveNextWallet()
+		if err != nil {
+			log.Panic(err)
+		}
+
+		return address
+	}
+
+	return ws.addWallet(NewWallet())
+}
+
+// addWallet stores the wallet under its address, sealing its key when the wallet is encrypted
+func (ws *Wallets) addWallet(wallet *Wallet) string {
 	address := fmt.Sprintf("%s", wallet.GetAddress())
+	ws.lockIfExpired()
+
+	if ws.IsEncrypted() && !wallet.IsWatchOnly() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+
+		if ws.key == nil {
+			log.Panic(errWalletLocked)
+		}
+
+		err := wallet.sealKey(ws.key)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 
 	ws.Wallets[address] = wallet
 	return address
 }
 
+// CreateTransaction a new transaction paying every recipient from outputs of several wallet addresses
+// Any change goes to a freshly created address, which is returned so the wallet file can be saved
+func (ws *Wallets) CreateTransaction(from []string, recipients []Recipient, feeRate int, selector CoinSelector, UTXOSet *chainstate.UTXOSet, history *History, allowUnconfirmed bool) (*transaction.Transaction, *CoinSelection, string) {
+	var owners []*Wallet
+	var changeAddress string
+
+	for _, address := range from {
+		wallet, ok := ws.findWallet(address)
+		if !ok {
+			log.Panicf("ERROR: Address %s is not in the wallet", address)
+		}
+
+		owners = append(owners, wallet)
+	}
+
+	newChangeAddress := func() string {
+		changeAddress = ws.CreateWallet()
+		return changeAddress
+	}
+
+	tx, selection := buildTransaction(owners, recipients, feeRate, selector, newChangeAddress, UTXOSet, history, allowUnconfirmed)
+
+	return tx, selection, changeAddress
+}
+
+// ImportPrivateKey adds the key encoded in the Wallet Import Format and returns its address
+// The key replaces a watch-only entry for the same address
+func (ws *Wallets) ImportPrivateKey(wif string) (string, error) {
+	privateKey, compressed, err := DecodeWIF(wif)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey := utils.PublicKeyBytes(privateKey.PublicKey)
+	if compressed {
+		publicKey = utils.CompressPubKey(privateKey.PublicKey)
+	}
+
+	wallet := &Wallet{PrivateKey: privateKey, PublicKey: publicKey}
+
+	if ws.IsLocked() {
+		return "", errWalletLocked
+	}
+
+	return ws.addWallet(wallet), nil
+}
+
+// ImportAddress adds a watch-only entry for an address the wallet holds no key for
+func (ws *Wallets) ImportAddress(address string) error {
+	if _, ok := ws.findWallet(address); ok {
+		return fmt.Errorf("ERROR: Address %s is already in the wallet", address)
+	}
+
+	pubKeyHash, err := utils.DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	ws.addWallet(NewWatchOnlyWallet(pubKeyHash))
+
+	return nil
+}
+
+// DumpPrivateKey returns the private key of an address in the Wallet Import Format
+func (ws *Wallets) DumpPrivateKey(address string) (string, error) {
+	wallet, ok := ws.findWallet(address)
+	if !ok {
+		return "", fmt.Errorf("ERROR: Address %s is not in the wallet", address)
+	}
+
+	if wallet.IsWatchOnly() {
+		return "", fmt.Errorf("ERROR: Address %s is watch-only", address)
+	}
+
+	if wallet.PrivateKey.D == nil {
+		return "", errWalletLocked
+	}
+
+	return EncodeWIF(wallet.PrivateKey, wallet.IsCompressed()), nil
+}
+
+// GetSpendableAddresses returns the addresses the wallet holds a private key for
+func (ws *Wallets) GetSpendableAddresses() []string {
+	var addresses []string
+
+	for address, wallet := range ws.GetWallets() {
+		if !wallet.IsWatchOnly() {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []string {
 	var addresses []string
@@ -54,14 +196,32 @@ func (ws *Wallets) GetAddresses() []string {
 
 // GetWallet returns a Wallet by its address
 func (ws *Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+	wallet, _ := ws.findWallet(address)
+	return *wallet
+}
+
+// findWallet looks a wallet up by its address in either format
+func (ws *Wallets) findWallet(address string) (*Wallet, bool) {
+	ws.lockIfExpired()
+
+	if wallet, ok := ws.Wallets[address]; ok {
+		return wallet, true
+	}
+
+	pubKeyHash, err := utils.DecodeAddress(address)
+	if err != nil {
+		return nil, false
+	}
+
+	wallet, ok := ws.Wallets[utils.EncodeAddress(pubKeyHash)]
+	return wallet, ok
 }
 
 // LoadFromFile loads wallets from the file
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := utils.DataPath(fmt.Sprintf(walletFile, nodeID))
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
-		file, err := os.Create(walletFile)
+		file, err := os.OpenFile(walletFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, walletFileMode)
 		if err != nil {
 			return err
 		}
@@ -75,7 +235,6 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 	}
 
 	var wallets Wallets
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 	if err != nil && !errors.Is(err, io.EOF) {
@@ -86,23 +245,47 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		ws.Wallets = wallets.GetWallets()
 	}
 
+	ws.Encryption = wallets.Encryption
+	ws.HDChain = wallets.HDChain
+
 	return nil
 }
 
 // SaveToFile saves wallets to a file
-func (ws Wallets) SaveToFile(nodeID string) {
+// Private keys of an encrypted wallet are only written in their sealed form
+func (ws *Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := utils.DataPath(fmt.Sprintf(walletFile, nodeID))
+
+	stored := make(map[string]*Wallet)
+	for address, wallet := range ws.Wallets {
+		if ws.IsEncrypted() {
+			sealed := *wallet
+			sealed.PrivateKey = ecdsa.PrivateKey{}
+			wallet = &sealed
+		}
+
+		stored[address] = wallet
+	}
 
-	gob.Register(elliptic.P256())
+	hdChain := ws.HDChain
+	if hdChain != nil && ws.IsEncrypted() {
+		hdChain = &HDChain{EncryptedEntropy: hdChain.EncryptedEntropy, AccountPath: hdChain.AccountPath, NextIndex: hdChain.NextIndex}
+	}
 
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	err := encoder.Encode(Wallets{Wallets: stored, Encryption: ws.Encryption, HDChain: hdChain})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = os.WriteFile(walletFile, content.Bytes(), walletFileMode)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = os.WriteFile(walletFile, content.Bytes(), 0644)
+	// WriteFile keeps the mode of an existing file, so files written by older versions are fixed here
+	err = os.Chmod(walletFile, walletFileMode)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+
+	"github.com/lugassawan/learning-golang-blockchain/chaincfg"
+	"github.com/lugassawan/learning-golang-blockchain/utils"
+)
+
+// compressedFlag follows the scalar of a key whose public key is used in the compressed format
+const compressedFlag = byte(0x01)
+
+var errInvalidWIF = errors.New("ERROR: Private key is not in a valid WIF format")
+
+// EncodeWIF encodes a private key in the Wallet Import Format, a Base58Check encoding of its scalar
+// The version byte is the one of the active network
+func EncodeWIF(privateKey ecdsa.PrivateKey, compressed bool) string {
+	versionedPayload := append([]byte{chaincfg.ActiveParams().PrivateKeyID()}, utils.PrivateKeyToBytes(privateKey)...)
+	if compressed {
+		versionedPayload = append(versionedPayload, compressedFlag)
+	}
+
+	checksum := utils.Checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+
+	return string(utils.Base58Encode(fullPayload))
+}
+
+// DecodeWIF decodes a private key in the Wallet Import Format and tells whether its public key is compressed
+// Keys of another network than the active one are rejected
+func DecodeWIF(wif string) (ecdsa.PrivateKey, bool, error) {
+	if wif == "" {
+		return ecdsa.PrivateKey{}, false, errInvalidWIF
+	}
+
+	fullPayload, err := utils.Base58Decode([]byte(wif))
+	if err != nil || len(fullPayload) < 1+32+4 || fullPayload[0] != chaincfg.ActiveParams().PrivateKeyID() {
+		return ecdsa.PrivateKey{}, false, errInvalidWIF
+	}
+
+	versionedPayload := fullPayload[:len(fullPayload)-4]
+	checksum := fullPayload[len(fullPayload)-4:]
+
+	if !bytes.Equal(checksum, utils.Checksum(versionedPayload)) {
+		return ecdsa.PrivateKey{}, false, errInvalidWIF
+	}
+
+	payload := versionedPayload[1:]
+	compressed := len(payload) == 33 && payload[32] == compressedFlag
+
+	if len(payload) != 32 && !compressed {
+		return ecdsa.PrivateKey{}, false, errInvalidWIF
+	}
+
+	privateKey, err := utils.PrivateKeyFromBytes(payload[:32])
+
+	return privateKey, compressed, err
+}